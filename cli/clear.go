@@ -40,6 +40,7 @@ func ConfigureClearCommand(app *kingpin.Application, a *AwsVault) {
 func ClearCommand(input ClearCommandInput, awsConfigFile *vault.ConfigFile, keyring keyring.Keyring) error {
 	sessions := &vault.SessionKeyring{Keyring: keyring}
 	oidcTokens := &vault.OIDCTokenKeyring{Keyring: keyring}
+	cognitoIdentities := &vault.CognitoIdentityKeyring{Keyring: keyring}
 	var oldSessionsRemoved, numSessionsRemoved, numTokensRemoved int
 	var err error
 	if input.ProfileName == "" {
@@ -55,6 +56,10 @@ func ClearCommand(input ClearCommandInput, awsConfigFile *vault.ConfigFile, keyr
 		if err != nil {
 			return err
 		}
+		_, err = cognitoIdentities.RemoveAll()
+		if err != nil {
+			return err
+		}
 	} else {
 		numSessionsRemoved, err = sessions.RemoveForProfile(input.ProfileName)
 		if err != nil {
@@ -69,6 +74,12 @@ func ClearCommand(input ClearCommandInput, awsConfigFile *vault.ConfigFile, keyr
 				}
 				numTokensRemoved = 1
 			}
+			if profileSection.CognitoIdentityPoolID != "" {
+				_, err = cognitoIdentities.RemoveForPool(profileSection.CognitoIdentityPoolID)
+				if err != nil {
+					return err
+				}
+			}
 		}
 	}
 	fmt.Printf("Cleared %d sessions.\n", oldSessionsRemoved+numSessionsRemoved+numTokensRemoved)
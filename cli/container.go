@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/99designs/aws-vault/v7/server"
+)
+
+const (
+	// containerHostName is the name containers use to reach the host
+	containerHostName = "host.docker.internal"
+
+	// dockerBridgeInterface is the interface of Docker's default bridge network on Linux
+	dockerBridgeInterface = "docker0"
+
+	// dockerAddressPool is the range Docker allocates bridge networks from by default on Linux
+	dockerAddressPool = "172.16.0.0/12"
+)
+
+// containerEnvVars are passed from the subprocess's environment to containers. AWS_CONTAINER_CREDENTIALS_FULL_URI
+// is set separately, as containers reach the server at a different address than the subprocess
+var containerEnvVars = []string{
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+	"AWS_CA_BUNDLE",
+	"AWS_REGION",
+	"AWS_DEFAULT_REGION",
+}
+
+// composeFiles are the files docker compose uses when COMPOSE_FILE isn't set, in order of preference
+var composeFiles = []string{"compose.yaml", "compose.yml", "docker-compose.yml", "docker-compose.yaml"}
+
+// setContainerListenDefaults listens where containers can connect to, unless the flags say otherwise.
+// Docker Desktop forwards host.docker.internal to the host's loopback interface, on Linux it's the
+// address of the docker0 bridge
+func setContainerListenDefaults(input *EcsListenInput) {
+	input.TLS = true
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if input.Bind == "" {
+		input.Bind = dockerBridgeInterface
+	}
+	if len(input.AllowCIDRs) == 0 {
+		input.AllowCIDRs = []string{dockerAddressPool}
+	}
+}
+
+// setContainerEnv sets the environment the subprocess needs to pass the ECS server to containers, which
+// reach it on host.docker.internal. The subprocess itself keeps using the server's host address. With
+// composeServices, it also writes a compose override file that passes the environment to those services
+func setContainerEnv(ecsServer *server.EcsServer, composeServices []string, cmdEnv *environ) error {
+	containerURL := &url.URL{
+		Scheme: "https",
+		Host:   fmt.Sprintf("%s:%d", containerHostName, ecsServer.Port()),
+	}
+	log.Println("Setting subprocess env AWS_VAULT_DOCKER_RUN_ARGS")
+	cmdEnv.Set("AWS_VAULT_DOCKER_RUN_ARGS", dockerRunArgs(containerURL.String(), ecsServer.CABundlePath()))
+
+	if len(composeServices) == 0 {
+		return nil
+	}
+
+	overrideFile := filepath.Join(filepath.Dir(ecsServer.CABundlePath()), "compose.aws-vault.yaml")
+	if err := os.WriteFile(overrideFile, []byte(composeOverride(composeServices, containerURL.String(), ecsServer.CABundlePath())), 0600); err != nil {
+		return err
+	}
+
+	composeFile := os.Getenv("COMPOSE_FILE")
+	if composeFile == "" {
+		composeFile = findComposeFiles()
+	}
+	if composeFile == "" {
+		return fmt.Errorf("No compose file found in the current directory, set COMPOSE_FILE to use --compose-service")
+	}
+
+	log.Println("Setting subprocess env COMPOSE_FILE")
+	cmdEnv.Set("COMPOSE_FILE", composeFile+string(os.PathListSeparator)+overrideFile)
+
+	return nil
+}
+
+// findComposeFiles returns the files docker compose would use in the current directory, as a COMPOSE_FILE value
+func findComposeFiles() string {
+	for _, f := range composeFiles {
+		if _, err := os.Stat(f); err != nil {
+			continue
+		}
+
+		files := []string{f}
+		override := strings.TrimSuffix(f, filepath.Ext(f)) + ".override" + filepath.Ext(f)
+		if _, err := os.Stat(override); err == nil {
+			files = append(files, override)
+		}
+		return strings.Join(files, string(os.PathListSeparator))
+	}
+	return ""
+}
+
+// composeOverride returns a compose file that passes the credential server's environment and CA bundle
+// to each service. Apart from the server's URL, values come from the environment docker compose runs in,
+// so no secrets are written
+func composeOverride(services []string, containerURL string, caBundlePath string) string {
+	var b strings.Builder
+	b.WriteString("# Generated by aws-vault\nservices:\n")
+	for _, s := range services {
+		fmt.Fprintf(&b, "  %s:\n", strconv.Quote(s))
+		b.WriteString("    environment:\n")
+		fmt.Fprintf(&b, "      - %s\n", strconv.Quote("AWS_CONTAINER_CREDENTIALS_FULL_URI="+containerURL))
+		for _, v := range containerEnvVars {
+			fmt.Fprintf(&b, "      - %s\n", v)
+		}
+		b.WriteString("    extra_hosts:\n")
+		fmt.Fprintf(&b, "      - %s\n", strconv.Quote(containerHostName+":host-gateway"))
+		b.WriteString("    volumes:\n")
+		fmt.Fprintf(&b, "      - %s\n", strconv.Quote(caBundlePath+":"+caBundlePath+":ro"))
+	}
+	return b.String()
+}
+
+// dockerRunArgs returns the arguments that pass the credential server's environment and CA bundle to docker run
+func dockerRunArgs(containerURL string, caBundlePath string) string {
+	args := []string{"-e", "AWS_CONTAINER_CREDENTIALS_FULL_URI=" + containerURL}
+	for _, v := range containerEnvVars {
+		args = append(args, "-e", v)
+	}
+	args = append(args, "-v", caBundlePath+":"+caBundlePath+":ro", "--add-host", containerHostName+":host-gateway")
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,10 @@
+package cli
+
+import "fmt"
+
+func Example_dockerRunArgs() {
+	fmt.Println(dockerRunArgs("https://host.docker.internal:1234", "/tmp/aws-vault/ca.pem"))
+
+	// Output:
+	// -e AWS_CONTAINER_CREDENTIALS_FULL_URI=https://host.docker.internal:1234 -e AWS_CONTAINER_AUTHORIZATION_TOKEN -e AWS_CA_BUNDLE -e AWS_REGION -e AWS_DEFAULT_REGION -v /tmp/aws-vault/ca.pem:/tmp/aws-vault/ca.pem:ro --add-host host.docker.internal:host-gateway
+}
@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/99designs/aws-vault/v7/server"
+	"github.com/alecthomas/kingpin/v2"
+)
+
+// controlRequestTimeout is long enough for a refresh that prompts for MFA
+const controlRequestTimeout = 5 * time.Minute
+
+func ConfigureStatusCommand(app *kingpin.Application) {
+	cmd := app.Command("status", "Show the credentials served by the ECS server of the current subshell.")
+
+	cmd.Action(func(c *kingpin.ParseContext) (err error) {
+		err = StatusCommand()
+		app.FatalIfError(err, "status")
+		return nil
+	})
+}
+
+func ConfigureRefreshCommand(app *kingpin.Application) {
+	cmd := app.Command("refresh", "Make the ECS server of the current subshell get a new session.")
+
+	cmd.Action(func(c *kingpin.ParseContext) (err error) {
+		err = RefreshCommand()
+		app.FatalIfError(err, "refresh")
+		return nil
+	})
+}
+
+func StatusCommand() error {
+	status, err := ecsControlRequest(http.MethodGet, "/control/status")
+	if err != nil {
+		return err
+	}
+	printServerStatus(status)
+	return nil
+}
+
+func RefreshCommand() error {
+	status, err := ecsControlRequest(http.MethodPost, "/control/refresh")
+	if err != nil {
+		return err
+	}
+	printServerStatus(status)
+	return nil
+}
+
+func printServerStatus(status *server.EcsServerStatus) {
+	fmt.Printf("Profile:     %s\n", status.Profile)
+	if status.RoleARN != "" {
+		fmt.Printf("Role:        %s\n", status.RoleARN)
+	}
+	fmt.Printf("Access key:  %s\n", status.AccessKeyID)
+	if status.Expiration != "" {
+		expiration := status.Expiration
+		if t, err := time.Parse(time.RFC3339, status.Expiration); err == nil {
+			expiration = fmt.Sprintf("%s (in %s)", expiration, time.Until(t).Round(time.Second))
+		}
+		fmt.Printf("Expires:     %s\n", expiration)
+	}
+}
+
+// ecsControlRequest sends a request to the control endpoints of the ECS server found in the environment
+func ecsControlRequest(method, path string) (*server.EcsServerStatus, error) {
+	uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if uri == "" {
+		return nil, errors.New("No ECS server found. Run this in a subshell started with `aws-vault exec --ecs-server`, or with the environment printed by `aws-vault server`")
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid AWS_CONTAINER_CREDENTIALS_FULL_URI: %w", err)
+	}
+	u.Path = path
+	u.RawQuery = ""
+
+	token, err := ecsAuthorizationToken()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: controlRequestTimeout}
+	if caBundle := os.Getenv("AWS_CA_BUNDLE"); u.Scheme == "https" && caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(pem)
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}}
+	}
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct{ Message string }
+		if json.Unmarshal(body, &e) == nil && e.Message != "" {
+			return nil, fmt.Errorf("ECS server: %s", e.Message)
+		}
+		return nil, fmt.Errorf("ECS server: %s", resp.Status)
+	}
+
+	status := &server.EcsServerStatus{}
+	if err = json.Unmarshal(body, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func ecsAuthorizationToken() (string, error) {
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		return token, nil
+	}
+	if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return "", errors.New("No ECS server authorization token found in AWS_CONTAINER_AUTHORIZATION_TOKEN or AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE")
+}
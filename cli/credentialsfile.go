@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/99designs/aws-vault/v7/iso8601"
+	"github.com/99designs/aws-vault/v7/vault"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ini "gopkg.in/ini.v1"
+)
+
+const (
+	// credentialsFileRefreshWindow is how long before expiry the credentials file is rewritten
+	credentialsFileRefreshWindow = time.Minute
+
+	// credentialsFileRetryInterval is the shortest time between refreshes of the credentials file
+	credentialsFileRetryInterval = 10 * time.Second
+)
+
+// credentialsFile is a private shared credentials and config file pair that is kept up to date
+// with credentials from a provider, for tools that re-read the credentials file
+type credentialsFile struct {
+	dir           string
+	credsProvider aws.CredentialsProvider
+	region        string
+	cancel        context.CancelFunc
+	done          chan struct{}
+
+	// refreshWindow and retryInterval default to credentialsFileRefreshWindow and credentialsFileRetryInterval
+	refreshWindow time.Duration
+	retryInterval time.Duration
+}
+
+func startCredentialsFile(ctx context.Context, credsProvider aws.CredentialsProvider, profileName, region string) (*credentialsFile, error) {
+	dir, err := os.MkdirTemp("", "aws-vault-")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &credentialsFile{
+		dir:           dir,
+		credsProvider: credsProvider,
+		region:        region,
+		done:          make(chan struct{}),
+		refreshWindow: credentialsFileRefreshWindow,
+		retryInterval: credentialsFileRetryInterval,
+	}
+
+	if err = c.writeConfig(); err != nil {
+		c.Remove()
+		return nil, err
+	}
+
+	creds, err := c.refresh(ctx)
+	if err != nil {
+		c.Remove()
+		return nil, fmt.Errorf("Failed to get credentials for %s: %w", profileName, err)
+	}
+
+	var loopCtx context.Context
+	loopCtx, c.cancel = context.WithCancel(ctx)
+	go c.refreshLoop(loopCtx, creds)
+
+	return c, nil
+}
+
+func (c *credentialsFile) CredentialsPath() string {
+	return filepath.Join(c.dir, "credentials")
+}
+
+func (c *credentialsFile) ConfigPath() string {
+	return filepath.Join(c.dir, "config")
+}
+
+// SetEnv points the AWS SDKs at the credentials and config files
+func (c *credentialsFile) SetEnv(env *environ) {
+	log.Println("Setting subprocess env: AWS_SHARED_CREDENTIALS_FILE, AWS_CONFIG_FILE")
+	env.Set("AWS_SHARED_CREDENTIALS_FILE", c.CredentialsPath())
+	env.Set("AWS_CONFIG_FILE", c.ConfigPath())
+}
+
+// Remove stops refreshing the credentials and removes the files
+func (c *credentialsFile) Remove() {
+	if c.cancel != nil {
+		c.cancel()
+		<-c.done
+	}
+	if err := os.RemoveAll(c.dir); err != nil {
+		log.Printf("Failed to remove %s: %s", c.dir, err.Error())
+	}
+}
+
+func (c *credentialsFile) refreshLoop(ctx context.Context, creds aws.Credentials) {
+	defer close(c.done)
+
+	if !creds.CanExpire {
+		return
+	}
+
+	for {
+		wait := time.Until(creds.Expires) - c.refreshWindow
+		if wait < c.retryInterval {
+			wait = c.retryInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		newCreds, err := c.refresh(ctx)
+		if err != nil {
+			log.Printf("Failed to refresh credentials file: %s", err.Error())
+			continue
+		}
+		creds = newCreds
+	}
+}
+
+func (c *credentialsFile) refresh(ctx context.Context) (aws.Credentials, error) {
+	creds, err := c.credsProvider.Retrieve(ctx)
+	if err != nil {
+		return creds, err
+	}
+
+	f := ini.Empty()
+	s, err := f.NewSection("default")
+	if err != nil {
+		return creds, err
+	}
+	mustNewKey(s, "aws_access_key_id", creds.AccessKeyID)
+	mustNewKey(s, "aws_secret_access_key", creds.SecretAccessKey)
+	mustNewKey(s, "aws_session_token", creds.SessionToken)
+	if creds.CanExpire {
+		mustNewKey(s, "aws_credential_expiration", iso8601.Format(creds.Expires))
+	}
+
+	if err = writeFileAtomic(c.CredentialsPath(), f); err != nil {
+		return creds, err
+	}
+
+	if creds.CanExpire {
+		log.Printf("Wrote credentials %s to %s, expires in %s", vault.FormatKeyForDisplay(creds.AccessKeyID), c.CredentialsPath(), time.Until(creds.Expires).String())
+	}
+
+	return creds, nil
+}
+
+func (c *credentialsFile) writeConfig() error {
+	f := ini.Empty()
+	s, err := f.NewSection("default")
+	if err != nil {
+		return err
+	}
+	mustNewKey(s, "region", c.region)
+
+	return writeFileAtomic(c.ConfigPath(), f)
+}
+
+// writeFileAtomic writes the ini file to a temporary file and renames it, so readers never see a partial file
+func writeFileAtomic(path string, f *ini.File) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = f.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/99designs/aws-vault/v7/iso8601"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ini "gopkg.in/ini.v1"
+)
+
+func readCredentialsFileSection(t *testing.T, path string) *ini.Section {
+	t.Helper()
+	f, err := ini.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.Section("default")
+}
+
+func TestCredentialsFileContents(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "ASIAEXAMPLE1", SecretAccessKey: "secret", SessionToken: "token", CanExpire: true, Expires: expires}, nil
+	})
+
+	c, err := startCredentialsFile(context.Background(), provider, "llamas", "eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Remove()
+
+	s := readCredentialsFileSection(t, c.CredentialsPath())
+	expected := map[string]string{
+		"aws_access_key_id":         "ASIAEXAMPLE1",
+		"aws_secret_access_key":     "secret",
+		"aws_session_token":         "token",
+		"aws_credential_expiration": iso8601.Format(expires),
+	}
+	for key, value := range expected {
+		if actual := s.Key(key).String(); actual != value {
+			t.Errorf("Expected %s = %q, got %q", key, value, actual)
+		}
+	}
+	if region := readCredentialsFileSection(t, c.ConfigPath()).Key("region").String(); region != "eu-west-1" {
+		t.Errorf("Expected the region in the config file, got %q", region)
+	}
+
+	env := environ{}
+	c.SetEnv(&env)
+	if len(env) != 2 || env[0] != "AWS_SHARED_CREDENTIALS_FILE="+c.CredentialsPath() || env[1] != "AWS_CONFIG_FILE="+c.ConfigPath() {
+		t.Errorf("Unexpected env %v", env)
+	}
+
+	if runtime.GOOS != "windows" {
+		for path, mode := range map[string]os.FileMode{c.dir: 0700, c.CredentialsPath(): 0600, c.ConfigPath(): 0600} {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != mode {
+				t.Errorf("Expected %s to have mode %s, got %s", path, mode, info.Mode().Perm())
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected only the credentials and config files, got %d files", len(entries))
+	}
+
+	c.Remove()
+	if _, err = os.Stat(c.dir); !os.IsNotExist(err) {
+		t.Fatalf("Expected the files to be removed, got %v", err)
+	}
+}
+
+func TestCredentialsFileRefreshesBeforeExpiry(t *testing.T) {
+	var mu sync.Mutex
+	var firstExpires time.Time
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstExpires.IsZero() {
+			firstExpires = time.Now().Add(time.Second)
+			return aws.Credentials{AccessKeyID: "ASIAFIRST", SecretAccessKey: "secret", CanExpire: true, Expires: firstExpires}, nil
+		}
+		return aws.Credentials{AccessKeyID: "ASIASECOND", SecretAccessKey: "secret", CanExpire: true, Expires: time.Now().Add(time.Hour)}, nil
+	})
+
+	c := &credentialsFile{
+		dir:           t.TempDir(),
+		credsProvider: provider,
+		done:          make(chan struct{}),
+		refreshWindow: 900 * time.Millisecond,
+		retryInterval: 10 * time.Millisecond,
+	}
+	creds, err := c.refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(c.CredentialsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ctx context.Context
+	ctx, c.cancel = context.WithCancel(context.Background())
+	go c.refreshLoop(ctx, creds)
+	defer c.Remove()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for readCredentialsFileSection(t, c.CredentialsPath()).Key("aws_access_key_id").String() != "ASIASECOND" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the credentials file to be refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	after, err := os.Stat(c.CredentialsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !after.ModTime().Before(firstExpires) {
+		t.Errorf("Expected the credentials to be refreshed before they expired at %s, got %s", firstExpires, after.ModTime())
+	}
+	if os.SameFile(before, after) {
+		t.Error("Expected the credentials file to be replaced rather than rewritten")
+	}
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+
+	"github.com/99designs/aws-vault/v7/server"
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func ConfigureEc2NamespaceCommand(app *kingpin.Application) {
+	var socketPath, command string
+	var args []string
+
+	cmd := app.Command("ec2-namespace-exec", "Run a command in a network namespace started by exec --ec2-namespace.").
+		Hidden()
+
+	cmd.Flag("socket", "The EC2 credential server socket").
+		Required().
+		StringVar(&socketPath)
+
+	cmd.Arg("cmd", "Command to execute").
+		Required().
+		StringVar(&command)
+
+	cmd.Arg("args", "Command arguments").
+		StringsVar(&args)
+
+	cmd.Action(func(*kingpin.ParseContext) error {
+		err := server.SetupEc2Namespace(socketPath)
+		app.FatalIfError(err, "ec2-namespace-exec")
+
+		exitcode, err := runSubProcess(command, args, os.Environ())
+		app.FatalIfError(err, "ec2-namespace-exec")
+
+		os.Exit(exitcode)
+		return nil
+	})
+}
+
+// runInEc2Namespace runs the command in an unprivileged user and network namespace, where
+// 169.254.169.254 is a private EC2 metadata endpoint only reachable by the command
+func runInEc2Namespace(ctx context.Context, credsProvider aws.CredentialsProvider, region string, input ExecCommandInput, env environ) (int, error) {
+	dir, err := os.MkdirTemp("", "aws-vault-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "ec2.sock")
+	if err = server.StartEc2CredentialsServerOnSocket(ctx, credsProvider, region, input.IMDSv2Only, socketPath); err != nil {
+		return 0, fmt.Errorf("Failed to start credential server: %w", err)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	args := append([]string{"ec2-namespace-exec", "--socket", socketPath, "--", input.Command}, input.Args...)
+	log.Printf("Starting a subprocess in a network namespace: %s %v", input.Command, input.Args)
+
+	cmd := osexec.Command(executable, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = env
+
+	ns, err := server.NewEc2Namespace(cmd)
+	if err != nil {
+		return 0, err
+	}
+	defer ns.Close()
+
+	return startAndWait(cmd, ns.Start)
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/99designs/aws-vault/v7/server"
+	"github.com/alecthomas/kingpin/v2"
+)
+
+// EcsListenInput controls where the ECS server listens and which clients may connect
+type EcsListenInput struct {
+	Bind       string
+	AllowCIDRs []string
+	TLS        bool
+}
+
+func configureEcsListenFlags(cmd *kingpin.CmdClause, input *EcsListenInput, helpPrefix string) {
+	cmd.Flag("bind", helpPrefix+"IP address or network interface (such as docker0) for the ECS server to listen on. Defaults to 127.0.0.1").
+		StringVar(&input.Bind)
+
+	cmd.Flag("allow-cidr", helpPrefix+"Allow clients from this network to connect to the ECS server. Can be comma-separated or repeated").
+		StringsVar(&input.AllowCIDRs)
+
+	cmd.Flag("tls", helpPrefix+"Serve HTTPS with a certificate from a locally generated CA, and set AWS_CA_BUNDLE for clients").
+		BoolVar(&input.TLS)
+}
+
+func (input EcsListenInput) isSet() bool {
+	return input.Bind != "" || len(input.AllowCIDRs) > 0 || input.TLS
+}
+
+// listenOptions validates the flags and converts them to server options
+func (input EcsListenInput) listenOptions(tlsDir string) (server.EcsListenOptions, error) {
+	opts := server.EcsListenOptions{}
+
+	if input.Bind != "" {
+		ip, err := server.ParseBindAddress(input.Bind)
+		if err != nil {
+			return opts, err
+		}
+		opts.IP = ip
+	}
+
+	for _, cidr := range splitCommaSeparated(input.AllowCIDRs) {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return opts, fmt.Errorf("Invalid --allow-cidr: %w", err)
+		}
+		opts.AllowedNetworks = append(opts.AllowedNetworks, ipNet)
+	}
+
+	if opts.IP != nil && !opts.IP.IsLoopback() {
+		// AWS SDKs only send credential requests over plain HTTP to loopback addresses
+		if !input.TLS {
+			return opts, fmt.Errorf("--bind to a non-loopback address requires --tls")
+		}
+		if len(opts.AllowedNetworks) == 0 {
+			return opts, fmt.Errorf("--bind to a non-loopback address requires --allow-cidr")
+		}
+	}
+
+	if input.TLS {
+		opts.TLSDir = tlsDir
+	}
+
+	return opts, nil
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 	osexec "os/exec"
 	"os/signal"
+	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"syscall"
@@ -27,6 +29,22 @@ type ExecCommandInput struct {
 	Args             []string
 	StartEc2Server   bool
 	StartEcsServer   bool
+	IMDSv2Only       bool
+	Ec2Namespace     bool
+	CredentialsFile  bool
+	EcsTokenFile     bool
+	TokenRotation    time.Duration
+	EcsListen        EcsListenInput
+	Container        bool
+	ExpiryWarning    time.Duration
+	ComposeServices  []string
+	Nested           bool
+	RoleHops         RoleHopsInput
+	CleanEnv         bool
+	KeepEnv          []string
+	AllowProfiles    []string
+	AllowExecutables []string
+	DescendantsOnly  bool
 	Lazy             bool
 	JSONDeprecated   bool
 	Config           vault.ProfileConfig
@@ -37,6 +55,9 @@ type ExecCommandInput struct {
 }
 
 func (input ExecCommandInput) validate() error {
+	if len(input.KeepEnv) > 0 && !input.CleanEnv {
+		return fmt.Errorf("--keep-env can only be used with --clean-env")
+	}
 	if input.StartEc2Server && input.StartEcsServer {
 		return fmt.Errorf("Can't use --ec2-server with --ecs-server")
 	}
@@ -52,6 +73,54 @@ func (input ExecCommandInput) validate() error {
 	if input.StartEcsServer && input.NoSession {
 		return fmt.Errorf("Can't use --ecs-server with --no-session")
 	}
+	if input.IMDSv2Only && !input.StartEc2Server {
+		return fmt.Errorf("--imdsv2-only can only be used with --ec2-server")
+	}
+	if input.Ec2Namespace && !input.StartEc2Server {
+		return fmt.Errorf("--ec2-namespace can only be used with --ec2-server")
+	}
+	if (len(input.AllowExecutables) > 0 || input.DescendantsOnly) && !hasCredentialServer(input) {
+		return fmt.Errorf("--allow-exe and --descendants-only can only be used with --ec2-server or --ecs-server")
+	}
+	if (len(input.AllowExecutables) > 0 || input.DescendantsOnly) && input.Ec2Namespace {
+		return fmt.Errorf("Can't use --allow-exe or --descendants-only with --ec2-namespace, only the command can reach the namespace's metadata endpoint")
+	}
+	if (len(input.AllowExecutables) > 0 || input.DescendantsOnly) && runtime.GOOS != "linux" {
+		return fmt.Errorf("--allow-exe and --descendants-only are only supported on Linux")
+	}
+	if input.EcsTokenFile && !input.StartEcsServer {
+		return fmt.Errorf("--ecs-token-file can only be used with --ecs-server")
+	}
+	if input.Container && (input.EcsTokenFile || len(input.AllowExecutables) > 0 || input.DescendantsOnly) {
+		return fmt.Errorf("Can't use --ecs-token-file, --allow-exe or --descendants-only with --container")
+	}
+	if len(input.ComposeServices) > 0 && !input.Container {
+		return fmt.Errorf("--compose-service can only be used with --container")
+	}
+	if input.ExpiryWarning < 0 {
+		return fmt.Errorf("--expiry-warning can't be negative")
+	}
+	if input.ExpiryWarning > 0 && hasBackgroundServer(input) {
+		return fmt.Errorf("Can't use --expiry-warning with --ec2-server, --ecs-server or --credentials-file, which refresh the credentials themselves")
+	}
+	if input.EcsListen.isSet() && !input.StartEcsServer {
+		return fmt.Errorf("--bind, --allow-cidr and --tls can only be used with --ecs-server")
+	}
+	if input.TokenRotation < 0 {
+		return fmt.Errorf("--token-rotation can't be negative")
+	}
+	if len(input.AllowProfiles) > 0 && !input.StartEcsServer {
+		return fmt.Errorf("--allow-profile can only be used with --ecs-server")
+	}
+	if input.CredentialsFile && (input.StartEc2Server || input.StartEcsServer) {
+		return fmt.Errorf("Can't use --credentials-file with --ec2-server or --ecs-server")
+	}
+	if input.CredentialsFile && input.JSONDeprecated {
+		return fmt.Errorf("Can't use --credentials-file with --json")
+	}
+	if input.CredentialsFile && input.Config.MfaPromptMethod == "terminal" {
+		return fmt.Errorf("Can't use --prompt=terminal with --credentials-file. Specify a different prompt driver")
+	}
 	if input.StartEcsServer && input.Config.MfaPromptMethod == "terminal" {
 		return fmt.Errorf("Can't use --prompt=terminal with --ecs-server. Specify a different prompt driver")
 	}
@@ -63,9 +132,27 @@ func (input ExecCommandInput) validate() error {
 }
 
 func hasBackgroundServer(input ExecCommandInput) bool {
+	return hasCredentialServer(input) || input.CredentialsFile
+}
+
+func hasCredentialServer(input ExecCommandInput) bool {
 	return input.StartEcsServer || input.StartEc2Server
 }
 
+// processAllowlist returns the processes allowed to request credentials from the servers, or nil if any process may
+func (input ExecCommandInput) processAllowlist() *server.ProcessAllowlist {
+	if len(input.AllowExecutables) == 0 && !input.DescendantsOnly {
+		return nil
+	}
+
+	allowlist := &server.ProcessAllowlist{Executables: input.AllowExecutables}
+	if input.DescendantsOnly {
+		// The command is run as a subprocess when using a server, so it descends from this process
+		allowlist.AncestorPID = os.Getpid()
+	}
+	return allowlist
+}
+
 func ConfigureExecCommand(app *kingpin.Application, a *AwsVault) {
 	input := ExecCommandInput{}
 
@@ -75,6 +162,9 @@ func ConfigureExecCommand(app *kingpin.Application, a *AwsVault) {
 		Short('d').
 		DurationVar(&input.SessionDuration)
 
+	cmd.Flag("min-ttl", "Refresh cached sessions with less than this time left before they expire. Defaults to 5m").
+		DurationVar(&input.Config.MinTTL)
+
 	cmd.Flag("no-session", "Skip creating STS session with GetSessionToken").
 		Short('n').
 		BoolVar(&input.NoSession)
@@ -98,15 +188,67 @@ func ConfigureExecCommand(app *kingpin.Application, a *AwsVault) {
 	cmd.Flag("ec2-server", "Run a EC2 metadata server in the background for credentials").
 		BoolVar(&input.StartEc2Server)
 
+	cmd.Flag("imdsv2-only", "When using --ec2-server, reject IMDSv1 requests that don't have a session token").
+		BoolVar(&input.IMDSv2Only)
+
+	cmd.Flag("ec2-namespace", "When using --ec2-server, run the command in an unprivileged network namespace with a private EC2 metadata endpoint, instead of using root. Linux only").
+		BoolVar(&input.Ec2Namespace)
+
 	cmd.Flag("ecs-server", "Run a ECS credential server in the background for credentials (the SDK or app must support AWS_CONTAINER_CREDENTIALS_FULL_URI)").
 		BoolVar(&input.StartEcsServer)
 
+	cmd.Flag("credentials-file", "Write credentials to a private shared credentials file that is refreshed before expiry (the SDK or app must re-read AWS_SHARED_CREDENTIALS_FILE)").
+		BoolVar(&input.CredentialsFile)
+
+	cmd.Flag("ecs-token-file", "When using --ecs-server, pass the authorization token in a private file with AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE instead of in the environment").
+		BoolVar(&input.EcsTokenFile)
+
+	cmd.Flag("token-rotation", "When using --ecs-token-file, how often to rotate the token. 0 disables rotation").
+		Default("15m").
+		DurationVar(&input.TokenRotation)
+
+	configureEcsListenFlags(cmd, &input.EcsListen, "When using --ecs-server, ")
+
+	cmd.Flag("container", "Run a ECS credential server that docker containers can reach on host.docker.internal, and set the environment for docker run and docker compose").
+		BoolVar(&input.Container)
+
+	cmd.Flag("compose-service", "When using --container, pass credentials to this docker compose service with an override file. Can be comma-separated or repeated").
+		StringsVar(&input.ComposeServices)
+
+	cmd.Flag("allow-profile", "When using --ecs-server, also serve credentials for these profiles on /profile/<name>. Can be comma-separated or repeated").
+		HintAction(a.MustGetProfileNames).
+		StringsVar(&input.AllowProfiles)
+
+	cmd.Flag("allow-exe", "When using --ec2-server or --ecs-server, only serve credentials to processes whose executable matches this pattern. Can be repeated. Linux only").
+		StringsVar(&input.AllowExecutables)
+
+	cmd.Flag("descendants-only", "When using --ec2-server or --ecs-server, only serve credentials to the command and its descendants. Linux only").
+		BoolVar(&input.DescendantsOnly)
+
+	expiryWarningSet := false
+	cmd.Flag("expiry-warning", "Warn on the terminal this long before the credentials in the environment expire. Keeps aws-vault running as the parent of the command. Can't be used with --ec2-server, --ecs-server or --credentials-file").
+		Envar("AWS_VAULT_EXPIRY_WARNING").
+		IsSetByUser(&expiryWarningSet).
+		DurationVar(&input.ExpiryWarning)
+
 	cmd.Flag("lazy", "When using --ecs-server, lazily fetch credentials").
 		BoolVar(&input.Lazy)
 
 	cmd.Flag("stdout", "Print the SSO link to the terminal without automatically opening the browser").
 		BoolVar(&input.UseStdout)
 
+	cmd.Flag("clean-env", "Don't pass the environment to the command, other than basic variables such as PATH, HOME and TERM").
+		BoolVar(&input.CleanEnv)
+
+	cmd.Flag("keep-env", "With --clean-env, also pass variables matching this pattern, such as 'TF_VAR_*'. Can be repeated").
+		StringsVar(&input.KeepEnv)
+
+	cmd.Flag("nested", "Allow running in an aws-vault subshell, starting a nested subshell for the profile").
+		BoolVar(&input.Nested)
+
+	configureSessionPolicyFlags(cmd, &input.Config)
+	configureRoleHopsFlags(cmd, &input.RoleHops)
+
 	cmd.Arg("profile", "Name of the profile").
 		Required().
 		HintAction(a.MustGetProfileNames).
@@ -119,12 +261,28 @@ func ConfigureExecCommand(app *kingpin.Application, a *AwsVault) {
 		StringsVar(&input.Args)
 
 	cmd.Action(func(c *kingpin.ParseContext) (err error) {
+		if input.Container {
+			input.StartEcsServer = true
+			setContainerListenDefaults(&input.EcsListen)
+		}
+		input.ComposeServices = splitCommaSeparated(input.ComposeServices)
+		if !expiryWarningSet && hasBackgroundServer(input) {
+			// AWS_VAULT_EXPIRY_WARNING only applies to credentials in the environment
+			input.ExpiryWarning = 0
+		}
 		input.Config.MfaPromptMethod = a.PromptDriver(hasBackgroundServer(input))
 		input.Config.NonChainedGetSessionTokenDuration = input.SessionDuration
 		input.Config.AssumeRoleDuration = input.SessionDuration
 		input.Config.SSOUseStdout = input.UseStdout
+		input.AllowProfiles = splitCommaSeparated(input.AllowProfiles)
 		input.ShowHelpMessages = !a.Debug && input.Command == "" && isATerminal() && os.Getenv("AWS_VAULT_DISABLE_HELP_MESSAGE") != "1"
 
+		if input.Nested {
+			if err = leaveSubshellEnv(); err != nil {
+				return err
+			}
+		}
+
 		f, err := a.AwsConfigFile()
 		if err != nil {
 			return err
@@ -142,11 +300,13 @@ func ConfigureExecCommand(app *kingpin.Application, a *AwsVault) {
 				Config:          input.Config,
 				SessionDuration: input.SessionDuration,
 				NoSession:       input.NoSession,
+				Nested:          input.Nested,
+				RoleHops:        input.RoleHops,
 			}
 
-			err = ExportCommand(exportCommandInput, f, keyring)
+			err = ExportCommand(context.Background(), exportCommandInput, f, keyring)
 		} else {
-			exitcode, err = ExecCommand(input, f, keyring)
+			exitcode, err = ExecCommand(context.Background(), input, f, keyring)
 		}
 
 		app.FatalIfError(err, "exec")
@@ -158,34 +318,53 @@ func ConfigureExecCommand(app *kingpin.Application, a *AwsVault) {
 	})
 }
 
-func ExecCommand(input ExecCommandInput, f *vault.ConfigFile, keyring keyring.Keyring) (exitcode int, err error) {
-	if os.Getenv("AWS_VAULT") != "" {
-		return 0, fmt.Errorf("running in an existing aws-vault subshell; 'exit' from the subshell or unset AWS_VAULT to force")
+func ExecCommand(ctx context.Context, input ExecCommandInput, f *vault.ConfigFile, keyring keyring.Keyring) (exitcode int, err error) {
+	if inSubshell() && !input.Nested {
+		return 0, fmt.Errorf("running in an existing aws-vault subshell; 'exit' from the subshell, or use --nested to switch profiles in a nested subshell")
 	}
 
 	if err := input.validate(); err != nil {
 		return 0, err
 	}
+	if err := input.RoleHops.validate(); err != nil {
+		return 0, err
+	}
+	if err := input.RoleHops.takeSessionPolicies(&input.Config); err != nil {
+		return 0, err
+	}
 
 	config, err := vault.NewConfigLoader(input.Config, f, input.ProfileName).GetProfileConfig(input.ProfileName)
 	if err != nil {
 		return 0, fmt.Errorf("Error loading config: %w", err)
 	}
+	if err := validateSessionPolicies(config); err != nil {
+		return 0, err
+	}
+	for _, p := range input.AllowProfiles {
+		if _, ok := f.ProfileSection(p); !ok {
+			return 0, fmt.Errorf("Profile '%s' given to --allow-profile not found in %s", p, f.Path)
+		}
+	}
 
 	credsProvider, err := vault.NewTempCredentialsProvider(config, &vault.CredentialKeyring{Keyring: keyring}, input.NoSession, false)
 	if err != nil {
 		return 0, fmt.Errorf("Error getting temporary credentials: %w", err)
 	}
+	credsProvider = input.RoleHops.wrap(credsProvider, config)
 
 	subshellHelp := ""
 	if input.Command == "" {
 		input.Command = getDefaultShell()
-		subshellHelp = fmt.Sprintf("Starting subshell %s, use `exit` to exit the subshell", input.Command)
+		subshellHelp = subshellHelpMessage(input.Command, input.ProfileName)
 	}
 
-	cmdEnv := createEnv(input.ProfileName, config.Region)
+	cmdEnv := createEnv(config, inheritedEnv(input.CleanEnv, input.KeepEnv))
 
-	if input.StartEc2Server {
+	if input.StartEc2Server && input.Ec2Namespace {
+		printHelpMessage("Starting a private EC2 credential server on 169.254.169.254:80 in a network namespace", input.ShowHelpMessages)
+		printHelpMessage(subshellHelp, input.ShowHelpMessages)
+		return runInEc2Namespace(ctx, credsProvider, config.Region, input, cmdEnv)
+	} else if input.StartEc2Server {
 		if server.IsProxyRunning() {
 			return 0, fmt.Errorf("Another process is already bound to 169.254.169.254:80")
 		}
@@ -196,22 +375,75 @@ func ExecCommand(input ExecCommandInput, f *vault.ConfigFile, keyring keyring.Ke
 		}
 		defer server.StopProxy()
 
-		if err = server.StartEc2CredentialsServer(context.TODO(), credsProvider, config.Region); err != nil {
+		if err = server.StartEc2CredentialsServer(ctx, credsProvider, config.Region, input.IMDSv2Only, input.processAllowlist()); err != nil {
 			return 0, fmt.Errorf("Failed to start credential server: %w", err)
 		}
 		printHelpMessage(subshellHelp, input.ShowHelpMessages)
 	} else if input.StartEcsServer {
 		printHelpMessage("Starting a local ECS credential server; your app's AWS sdk must support AWS_CONTAINER_CREDENTIALS_FULL_URI.", input.ShowHelpMessages)
-		if err = startEcsServerAndSetEnv(credsProvider, config, input.Lazy, &cmdEnv); err != nil {
+		var tlsDir string
+		if input.EcsListen.TLS {
+			if tlsDir, err = os.MkdirTemp("", "aws-vault-tls-"); err != nil {
+				return 0, err
+			}
+			defer os.RemoveAll(tlsDir)
+		}
+		listen, err := input.EcsListen.listenOptions(tlsDir)
+		if err != nil {
+			return 0, err
+		}
+		ecsServer, err := startEcsServerAndSetEnv(ctx, credsProvider, config, listen, input.Lazy, &cmdEnv, func(e *server.EcsServer) {
+			e.RestrictProcesses(input.processAllowlist())
+			e.SetSessionKeyring(&vault.SessionKeyring{Keyring: keyring})
+			if len(input.AllowProfiles) > 0 {
+				profileConfig := input.Config
+				profileConfig.MfaToken = ""
+				e.ServeProfiles(profileConfig, f, &vault.CredentialKeyring{Keyring: keyring}, input.AllowProfiles)
+			}
+		})
+		if err != nil {
+			return 0, err
+		}
+		if input.EcsTokenFile {
+			removeTokenFile, err := setEcsTokenFileEnv(ecsServer, input.TokenRotation, &cmdEnv)
+			if err != nil {
+				return 0, err
+			}
+			defer removeTokenFile()
+		}
+		if input.Container {
+			if err = setContainerEnv(ecsServer, input.ComposeServices, &cmdEnv); err != nil {
+				return 0, err
+			}
+			printHelpMessage("Containers started with `docker run $AWS_VAULT_DOCKER_RUN_ARGS ...` get credentials from the server", input.ShowHelpMessages)
+		}
+		for _, p := range input.AllowProfiles {
+			printHelpMessage(fmt.Sprintf("Credentials for profile %s are served on %s", p, ecsServer.ProfileURL(p)), input.ShowHelpMessages)
+		}
+		printHelpMessage(subshellHelp, input.ShowHelpMessages)
+	} else if input.CredentialsFile {
+		credsFile, err := startCredentialsFile(ctx, credsProvider, input.ProfileName, config.Region)
+		if err != nil {
 			return 0, err
 		}
+		defer credsFile.Remove()
+
+		credsFile.SetEnv(&cmdEnv)
 		printHelpMessage(subshellHelp, input.ShowHelpMessages)
 	} else {
-		if err = addCredsToEnv(credsProvider, input.ProfileName, &cmdEnv); err != nil {
+		creds, err := addCredsToEnv(ctx, credsProvider, input.ProfileName, &cmdEnv)
+		if err != nil {
 			return 0, err
 		}
 		printHelpMessage(subshellHelp, input.ShowHelpMessages)
 
+		// Warnings need aws-vault to keep running, so the command is run as a subprocess rather than exec'd
+		if input.ExpiryWarning > 0 && creds.CanExpire {
+			stopWarnings := warnBeforeExpiry(input.ProfileName, creds.Expires, input.ExpiryWarning)
+			defer stopWarnings()
+			return runSubProcess(input.Command, input.Args, cmdEnv)
+		}
+
 		err = doExecSyscall(input.Command, input.Args, cmdEnv) // will not return if exec syscall succeeds
 		if err != nil {
 			log.Println("Error doing execve syscall:", err.Error())
@@ -236,8 +468,10 @@ func printToStderr(helpMsg string) {
 	fmt.Fprint(os.Stderr, helpMsg, "\n")
 }
 
-func createEnv(profileName string, region string) environ {
-	env := environ(os.Environ())
+// createEnv returns the inherited environment with the profile's region, variables and endpoints, and without
+// any AWS credentials or profile
+func createEnv(config *vault.ProfileConfig, env environ) environ {
+	profileName, region := config.ProfileName, config.Region
 	env.Unset("AWS_ACCESS_KEY_ID")
 	env.Unset("AWS_SECRET_ACCESS_KEY")
 	env.Unset("AWS_SESSION_TOKEN")
@@ -247,6 +481,8 @@ func createEnv(profileName string, region string) environ {
 	env.Unset("AWS_PROFILE")
 	env.Unset("AWS_SDK_LOAD_CONFIG")
 
+	extraEnv := profileEnv(config)
+	setSubshellEnv(&env, profileName, extraEnv)
 	env.Set("AWS_VAULT", profileName)
 
 	if region != "" {
@@ -257,13 +493,63 @@ func createEnv(profileName string, region string) environ {
 		env.Set("AWS_DEFAULT_REGION", region)
 	}
 
+	for key, value := range extraEnv {
+		log.Printf("Setting subprocess env: %s", key)
+		env.Set(key, value)
+	}
+
+	return env
+}
+
+// profileEnv returns the endpoint variables for the profile's endpoint_url and [services], and its aws_vault_env
+// variables, which can override them
+func profileEnv(config *vault.ProfileConfig) map[string]string {
+	env := map[string]string{}
+	if config.EndpointURL != "" {
+		env["AWS_ENDPOINT_URL"] = config.EndpointURL
+	}
+	for service, url := range config.ServiceEndpointURLs {
+		env["AWS_ENDPOINT_URL_"+strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(service))] = url
+	}
+	for key, value := range config.Env {
+		env[key] = value
+	}
 	return env
 }
 
-func startEcsServerAndSetEnv(credsProvider aws.CredentialsProvider, config *vault.ProfileConfig, lazy bool, cmdEnv *environ) error {
-	ecsServer, err := server.NewEcsServer(context.TODO(), credsProvider, config, "", 0, lazy)
+// cleanEnvKeep are the variables kept by --clean-env, so the command can still find programs and use the terminal
+var cleanEnvKeep = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "LANG", "LC_*", "TZ", "TMPDIR",
+	"SYSTEMROOT", "COMSPEC", "PATHEXT", "TEMP", "TMP", "USERPROFILE", "APPDATA", "LOCALAPPDATA"}
+
+// inheritedEnv returns the environment of aws-vault. With clean, only the cleanEnvKeep variables and those
+// matching the keep patterns are returned
+func inheritedEnv(clean bool, keep []string) environ {
+	if !clean {
+		return environ(os.Environ())
+	}
+
+	env := environ{}
+	patterns := append(append([]string{}, cleanEnvKeep...), keep...)
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, key); ok {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	log.Printf("Starting with a clean environment of %d variables", len(env))
+	return env
+}
+
+func startEcsServerAndSetEnv(ctx context.Context, credsProvider aws.CredentialsProvider, config *vault.ProfileConfig, listen server.EcsListenOptions, lazy bool, cmdEnv *environ, configure func(*server.EcsServer)) (*server.EcsServer, error) {
+	ecsServer, err := server.NewEcsServer(ctx, credsProvider, config, "", listen, lazy)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if configure != nil {
+		configure(ecsServer)
 	}
 	go func() {
 		err = ecsServer.Serve()
@@ -275,14 +561,54 @@ func startEcsServerAndSetEnv(credsProvider aws.CredentialsProvider, config *vaul
 	log.Println("Setting subprocess env AWS_CONTAINER_CREDENTIALS_FULL_URI, AWS_CONTAINER_AUTHORIZATION_TOKEN")
 	cmdEnv.Set("AWS_CONTAINER_CREDENTIALS_FULL_URI", ecsServer.BaseURL())
 	cmdEnv.Set("AWS_CONTAINER_AUTHORIZATION_TOKEN", ecsServer.AuthToken())
+	if ecsServer.CABundlePath() != "" {
+		log.Println("Setting subprocess env AWS_CA_BUNDLE")
+		cmdEnv.Set("AWS_CA_BUNDLE", ecsServer.CABundlePath())
+	}
 
-	return nil
+	return ecsServer, nil
+}
+
+// setEcsTokenFileEnv writes the ECS server's token to a file in a private directory and passes the
+// file to the subprocess in place of the token, rotating the token if interval is set
+func setEcsTokenFileEnv(ecsServer *server.EcsServer, interval time.Duration, cmdEnv *environ) (func(), error) {
+	dir, err := os.MkdirTemp("", "aws-vault-ecs-")
+	if err != nil {
+		return nil, err
+	}
+	tokenFile := filepath.Join(dir, "token")
+	if err = ecsServer.SetTokenFile(tokenFile); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if interval > 0 {
+		ecsServer.RotateTokens(interval)
+	}
+
+	log.Println("Setting subprocess env AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE")
+	cmdEnv.Unset("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	cmdEnv.Set("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", tokenFile)
+
+	return func() { os.RemoveAll(dir) }, nil
+}
+
+// splitCommaSeparated splits each value on commas, so flags can be given as "a,b" or repeated
+func splitCommaSeparated(values []string) []string {
+	result := []string{}
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
 }
 
-func addCredsToEnv(credsProvider aws.CredentialsProvider, profileName string, cmdEnv *environ) error {
-	creds, err := credsProvider.Retrieve(context.TODO())
+func addCredsToEnv(ctx context.Context, credsProvider aws.CredentialsProvider, profileName string, cmdEnv *environ) (aws.Credentials, error) {
+	creds, err := credsProvider.Retrieve(ctx)
 	if err != nil {
-		return fmt.Errorf("Failed to get credentials for %s: %w", profileName, err)
+		return creds, fmt.Errorf("Failed to get credentials for %s: %w", profileName, err)
 	}
 
 	log.Println("Setting subprocess env: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY")
@@ -298,7 +624,23 @@ func addCredsToEnv(credsProvider aws.CredentialsProvider, profileName string, cm
 		cmdEnv.Set("AWS_CREDENTIAL_EXPIRATION", iso8601.Format(creds.Expires))
 	}
 
-	return nil
+	return creds, nil
+}
+
+// warnBeforeExpiry prints a warning on the terminal when the credentials are about to expire, and when
+// they have expired. The returned function stops the warnings
+func warnBeforeExpiry(profileName string, expires time.Time, window time.Duration) func() {
+	warn := time.AfterFunc(time.Until(expires)-window, func() {
+		fmt.Fprintf(os.Stderr, "\naws-vault: credentials for profile %s expire in %s, exit this shell and run aws-vault exec again for new ones\n", profileName, time.Until(expires).Round(time.Second))
+	})
+	expired := time.AfterFunc(time.Until(expires), func() {
+		fmt.Fprintf(os.Stderr, "\naws-vault: credentials for profile %s have expired\n", profileName)
+	})
+
+	return func() {
+		warn.Stop()
+		expired.Stop()
+	}
 }
 
 // environ is a slice of strings representing the environment, in the form "key=value".
@@ -342,22 +684,37 @@ func runSubProcess(command string, args []string, env []string) (int, error) {
 	cmd.Stderr = os.Stderr
 	cmd.Env = env
 
+	return startAndWait(cmd, cmd.Start)
+}
+
+// startAndWait starts a command with the start func, proxying signals to it until it exits
+func startAndWait(cmd *osexec.Cmd, start func() error) (int, error) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan)
 
-	if err := cmd.Start(); err != nil {
+	if err := start(); err != nil {
+		signal.Stop(sigChan)
 		return 0, err
 	}
 
-	// proxy signals to process
+	// proxy signals to the process until it exits, so they don't go to commands that have already exited
+	proxied := make(chan struct{})
 	go func() {
-		for {
-			sig := <-sigChan
+		defer close(proxied)
+		for sig := range sigChan {
 			_ = cmd.Process.Signal(sig)
 		}
 	}()
 
-	if err := cmd.Wait(); err != nil {
+	err := cmd.Wait()
+	signal.Stop(sigChan)
+	close(sigChan)
+	<-proxied
+
+	if err != nil {
+		if exitErr, ok := err.(*osexec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
 		_ = cmd.Process.Signal(os.Kill)
 		return 0, fmt.Errorf("Failed to wait for command termination: %v", err)
 	}
@@ -1,6 +1,9 @@
 package cli
 
 import (
+	"testing"
+	"time"
+
 	"github.com/alecthomas/kingpin/v2"
 
 	"github.com/99designs/keyring"
@@ -20,3 +23,24 @@ func ExampleExecCommand() {
 	// Output:
 	// ABC
 }
+
+func TestExecCommandInputValidateExpiryWarning(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   ExecCommandInput
+		wantErr bool
+	}{
+		{"env credentials", ExecCommandInput{ExpiryWarning: time.Minute}, false},
+		{"ec2 server", ExecCommandInput{ExpiryWarning: time.Minute, StartEc2Server: true}, true},
+		{"ecs server", ExecCommandInput{ExpiryWarning: time.Minute, StartEcsServer: true}, true},
+		{"credentials file", ExecCommandInput{ExpiryWarning: time.Minute, CredentialsFile: true}, true},
+		{"ecs server without warning", ExecCommandInput{StartEcsServer: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.input.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"bufio"
+	osexec "os/exec"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStartAndWaitForwardsSignals(t *testing.T) {
+	goroutines := 0
+	for i := 0; i < 3; i++ {
+		cmd := osexec.Command("sh", "-c", `trap 'exit 7' USR1; echo ready; while :; do sleep 0.01; done`)
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := func() error {
+			if err := cmd.Start(); err != nil {
+				return err
+			}
+			go func() {
+				// Signal aws-vault once the command is ready for it
+				if _, err := bufio.NewReader(stdout).ReadString('\n'); err == nil {
+					_ = syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+				}
+			}()
+			return nil
+		}
+
+		code, err := startAndWait(cmd, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != 7 {
+			t.Fatalf("Expected the command to exit with 7 after receiving the signal, got %d", code)
+		}
+		if i == 0 {
+			// The first use of os/signal starts a goroutine that stays running
+			time.Sleep(10 * time.Millisecond)
+			goroutines = runtime.NumGoroutine()
+		}
+	}
+
+	// The goroutines proxying signals exit with their commands
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d goroutines, got %d", goroutines, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartAndWaitExitCode(t *testing.T) {
+	cmd := osexec.Command("sh", "-c", "exit 3")
+	code, err := startAndWait(cmd, cmd.Start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 {
+		t.Fatalf("Expected exit code 3, got %d", code)
+	}
+}
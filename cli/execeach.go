@@ -0,0 +1,339 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	osexec "os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/99designs/aws-vault/v7/vault"
+	"github.com/99designs/keyring"
+	"github.com/alecthomas/kingpin/v2"
+)
+
+// errExecEachSkipped is the error of profiles the command wasn't run for after a failure with --fail-fast
+var errExecEachSkipped = errors.New("skipped after an earlier profile failed")
+
+type ExecEachCommandInput struct {
+	Profiles        []string
+	Command         string
+	Args            []string
+	Parallel        int
+	Summary         string
+	Nested          bool
+	FailFast        bool
+	Config          vault.ProfileConfig
+	SessionDuration time.Duration
+	NoSession       bool
+	UseStdout       bool
+}
+
+// ExecEachResult is the outcome of running the command for one profile
+type ExecEachResult struct {
+	Profile  string  `json:"profile"`
+	ExitCode int     `json:"exit_code"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+func ConfigureExecEachCommand(app *kingpin.Application, a *AwsVault) {
+	input := ExecEachCommandInput{}
+
+	cmd := app.Command("exec-each", "Execute a command with AWS credentials for each of several profiles.")
+
+	cmd.Flag("profiles", "Profiles to run the command for, as names or glob patterns such as 'prod-*'. Can be comma-separated or repeated").
+		Required().
+		HintAction(a.MustGetProfileNames).
+		StringsVar(&input.Profiles)
+
+	cmd.Flag("parallel", "Number of profiles to run the command for at the same time").
+		Short('p').
+		Default("4").
+		IntVar(&input.Parallel)
+
+	cmd.Flag("summary", "Write a JSON summary of the results to this file").
+		StringVar(&input.Summary)
+
+	cmd.Flag("duration", "Duration of the temporary or assume-role session. Defaults to 1h").
+		Short('d').
+		DurationVar(&input.SessionDuration)
+
+	cmd.Flag("no-session", "Skip creating STS session with GetSessionToken").
+		Short('n').
+		BoolVar(&input.NoSession)
+
+	cmd.Flag("region", "The AWS region").
+		StringVar(&input.Config.Region)
+
+	cmd.Flag("stdout", "Print the SSO link to the terminal without automatically opening the browser").
+		BoolVar(&input.UseStdout)
+
+	cmd.Flag("nested", "Allow running in an aws-vault subshell, ignoring the environment it set").
+		BoolVar(&input.Nested)
+
+	cmd.Flag("fail-fast", "Stop starting the command for further profiles once it fails for one").
+		BoolVar(&input.FailFast)
+
+	cmd.Arg("cmd", "Command to execute").
+		Required().
+		StringVar(&input.Command)
+
+	cmd.Arg("args", "Command arguments").
+		StringsVar(&input.Args)
+
+	cmd.Action(func(c *kingpin.ParseContext) (err error) {
+		input.Profiles = splitCommaSeparated(input.Profiles)
+		input.Config.MfaPromptMethod = a.PromptDriver(false)
+		input.Config.NonChainedGetSessionTokenDuration = input.SessionDuration
+		input.Config.AssumeRoleDuration = input.SessionDuration
+		input.Config.SSOUseStdout = input.UseStdout
+
+		if input.Nested {
+			if err = leaveSubshellEnv(); err != nil {
+				return err
+			}
+		}
+
+		f, err := a.AwsConfigFile()
+		if err != nil {
+			return err
+		}
+		keyring, err := a.Keyring()
+		if err != nil {
+			return err
+		}
+
+		exitcode, err := ExecEachCommand(context.Background(), input, f, keyring)
+		app.FatalIfError(err, "exec-each")
+
+		if exitcode != 0 {
+			os.Exit(exitcode)
+		}
+		return nil
+	})
+}
+
+// ExecEachCommand gets credentials for each profile in turn, so MFA prompts don't overlap and chained
+// sessions are reused from the keyring, then runs the command for the profiles concurrently. It returns
+// 1 if the command couldn't be run or failed for any of the profiles. With FailFast, profiles after
+// a failure are skipped, but commands that are already running are left to finish
+func ExecEachCommand(ctx context.Context, input ExecEachCommandInput, f *vault.ConfigFile, keyring keyring.Keyring) (exitcode int, err error) {
+	if inSubshell() && !input.Nested {
+		return 0, fmt.Errorf("running in an existing aws-vault subshell; 'exit' from the subshell, or use --nested to ignore its environment")
+	}
+	if input.Parallel < 1 {
+		return 0, fmt.Errorf("--parallel must be at least 1")
+	}
+
+	profiles, err := matchProfiles(input.Profiles, f.ProfileNames())
+	if err != nil {
+		return 0, err
+	}
+
+	// One creator is used for every profile, so a chained MFA session is shared between them
+	creator := &vault.TempCredentialsCreator{
+		Keyring:         &vault.CredentialKeyring{Keyring: keyring},
+		DisableSessions: input.NoSession,
+	}
+
+	var mu sync.Mutex
+	failed := false
+	hasFailed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return failed && input.FailFast
+	}
+	fail := func(result *ExecEachResult, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = true
+		if err != nil {
+			result.Error = err.Error()
+		}
+	}
+
+	results := make([]ExecEachResult, len(profiles))
+	envs := make([]environ, len(profiles))
+	for i, profileName := range profiles {
+		results[i].Profile = profileName
+		if hasFailed() {
+			results[i].Error = errExecEachSkipped.Error()
+			continue
+		}
+		env, err := execEachEnv(ctx, input, f, creator, profileName)
+		if err != nil {
+			log.Printf("profile %s: %s", profileName, err.Error())
+			printToStderr(fmt.Sprintf("[%s] %s", profileName, err.Error()))
+			fail(&results[i], err)
+			continue
+		}
+		envs[i] = env
+	}
+
+	var outMu sync.Mutex
+	sem := make(chan struct{}, input.Parallel)
+	var wg sync.WaitGroup
+	for i := range profiles {
+		if envs[i] == nil {
+			continue
+		}
+
+		sem <- struct{}{}
+		if hasFailed() {
+			<-sem
+			results[i].Error = errExecEachSkipped.Error()
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *ExecEachResult, env environ) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			stdout := &prefixWriter{mu: &outMu, w: os.Stdout, prefix: "[" + result.Profile + "] "}
+			stderr := &prefixWriter{mu: &outMu, w: os.Stderr, prefix: "[" + result.Profile + "] "}
+			defer stdout.Flush()
+			defer stderr.Flush()
+
+			cmd := osexec.Command(input.Command, input.Args...)
+			cmd.Stdout = stdout
+			cmd.Stderr = stderr
+			cmd.Env = env
+
+			start := time.Now()
+			code, err := startAndWait(cmd, cmd.Start)
+			result.Duration = time.Since(start).Seconds()
+			result.ExitCode = code
+			if code != 0 || err != nil {
+				fail(result, err)
+			}
+		}(&results[i], envs[i])
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		if r.ExitCode != 0 || r.Error != "" {
+			exitcode = 1
+		}
+	}
+
+	return exitcode, writeExecEachSummary(input.Summary, results)
+}
+
+// matchProfiles returns the profiles matching the patterns, in the order they are in the config file.
+// Names without glob characters are used as they are, so profiles that are only in the keyring can be given
+func matchProfiles(patterns []string, profileNames []string) ([]string, error) {
+	matches := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			matches = append(matches, name)
+		}
+	}
+
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, `*?[\`) {
+			add(pattern)
+			continue
+		}
+
+		found := false
+		for _, name := range profileNames {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid profile pattern '%s': %w", pattern, err)
+			}
+			if ok {
+				found = true
+				add(name)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("No profiles match '%s'", pattern)
+		}
+	}
+
+	return matches, nil
+}
+
+func execEachEnv(ctx context.Context, input ExecEachCommandInput, f *vault.ConfigFile, creator *vault.TempCredentialsCreator, profileName string) (environ, error) {
+	config, err := vault.NewConfigLoader(input.Config, f, profileName).GetProfileConfig(profileName)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading config: %w", err)
+	}
+	if err := validateSessionPolicies(config); err != nil {
+		return nil, err
+	}
+
+	credsProvider, err := creator.GetProviderForProfile(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting temporary credentials: %w", err)
+	}
+
+	env := createEnv(config, inheritedEnv(false, nil))
+	if _, err = addCredsToEnv(ctx, credsProvider, profileName, &env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func writeExecEachSummary(summary string, results []ExecEachResult) error {
+	if summary == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(summary, append(b, '\n'), 0600)
+}
+
+// prefixWriter writes whole lines to w with a prefix, so the output of concurrent commands isn't interleaved
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes a final line that wasn't terminated by a newline
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		_ = p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := io.WriteString(p.w, p.prefix+string(line))
+	return err
+}
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"github.com/99designs/aws-vault/v7/vault"
+	"github.com/99designs/keyring"
+)
+
+func ExampleExecEachCommand() {
+	app := kingpin.New("aws-vault", "")
+	awsVault := ConfigureGlobals(app)
+	awsVault.keyringImpl = keyring.NewArrayKeyring([]keyring.Item{
+		{Key: "llamas", Data: []byte(`{"AccessKeyID":"ABC","SecretAccessKey":"XYZ"}`)},
+		{Key: "alpacas", Data: []byte(`{"AccessKeyID":"DEF","SecretAccessKey":"XYZ"}`)},
+	})
+	ConfigureExecEachCommand(app, awsVault)
+	kingpin.MustParse(app.Parse([]string{
+		"exec-each", "--no-session", "--parallel=1", "--profiles=llamas,alpacas", "--", "sh", "-c", "echo $AWS_ACCESS_KEY_ID",
+	}))
+
+	// Output:
+	// [llamas] ABC
+	// [alpacas] DEF
+}
+
+func newExecEachTestConfig(t *testing.T) (*vault.ConfigFile, keyring.Keyring) {
+	t.Helper()
+	t.Setenv("AWS_CA_BUNDLE", "")
+	t.Setenv("AWS_VAULT", "")
+
+	path := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(path, []byte(`
+[profile first]
+region=us-east-1
+
+[profile failing]
+region=us-east-1
+
+[profile last]
+region=us-east-1
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := vault.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f, keyring.NewArrayKeyring([]keyring.Item{
+		{Key: "first", Data: []byte(`{"AccessKeyID":"AKIAFIRST","SecretAccessKey":"XYZ"}`)},
+		{Key: "failing", Data: []byte(`{"AccessKeyID":"AKIAFAILING","SecretAccessKey":"XYZ"}`)},
+		{Key: "last", Data: []byte(`{"AccessKeyID":"AKIALAST","SecretAccessKey":"XYZ"}`)},
+	})
+}
+
+func readExecEachSummary(t *testing.T, path string) []ExecEachResult {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []ExecEachResult{}
+	if err = json.Unmarshal(b, &results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestExecEachCommandExitCodes(t *testing.T) {
+	f, kr := newExecEachTestConfig(t)
+	// The command fails with exit code 3 for the failing profile
+	command := []string{"-c", `test "$AWS_ACCESS_KEY_ID" != AKIAFAILING || exit 3`}
+
+	tests := []struct {
+		name      string
+		profiles  []string
+		failFast  bool
+		exitcode  int
+		exitcodes []int
+		errors    []string
+	}{
+		{"all succeed", []string{"first", "last"}, false, 0, []int{0, 0}, []string{"", ""}},
+		{"one fails", []string{"first", "failing", "last"}, false, 1, []int{0, 3, 0}, []string{"", "", ""}},
+		{"fail fast", []string{"first", "failing", "last"}, true, 1, []int{0, 3, 0}, []string{"", "", errExecEachSkipped.Error()}},
+		{"missing credentials", []string{"first", "missing", "last"}, false, 1, []int{0, 0, 0}, []string{"", "credentials missing", ""}},
+		{"missing credentials fail fast", []string{"missing", "first", "last"}, true, 1, []int{0, 0, 0}, []string{"credentials missing", errExecEachSkipped.Error(), errExecEachSkipped.Error()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := filepath.Join(t.TempDir(), "summary.json")
+			exitcode, err := ExecEachCommand(context.Background(), ExecEachCommandInput{
+				Profiles:  tt.profiles,
+				Command:   "sh",
+				Args:      command,
+				Parallel:  1,
+				Summary:   summary,
+				FailFast:  tt.failFast,
+				NoSession: true,
+			}, f, kr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exitcode != tt.exitcode {
+				t.Errorf("Expected exit code %d, got %d", tt.exitcode, exitcode)
+			}
+
+			results := readExecEachSummary(t, summary)
+			if len(results) != len(tt.profiles) {
+				t.Fatalf("Expected %d results, got %v", len(tt.profiles), results)
+			}
+			for i, r := range results {
+				if r.Profile != tt.profiles[i] || r.ExitCode != tt.exitcodes[i] || !strings.Contains(r.Error, tt.errors[i]) || (tt.errors[i] == "" && r.Error != "") {
+					t.Errorf("Expected %s to exit with %d and error %q, got %+v", tt.profiles[i], tt.exitcodes[i], tt.errors[i], r)
+				}
+			}
+		})
+	}
+}
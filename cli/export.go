@@ -23,6 +23,8 @@ type ExportCommandInput struct {
 	SessionDuration time.Duration
 	NoSession       bool
 	UseStdout       bool
+	Nested          bool
+	RoleHops        RoleHopsInput
 }
 
 var (
@@ -41,6 +43,9 @@ func ConfigureExportCommand(app *kingpin.Application, a *AwsVault) {
 		Short('d').
 		DurationVar(&input.SessionDuration)
 
+	cmd.Flag("min-ttl", "Refresh cached sessions with less than this time left before they expire. Defaults to 5m").
+		DurationVar(&input.Config.MinTTL)
+
 	cmd.Flag("no-session", "Skip creating STS session with GetSessionToken").
 		Short('n').
 		BoolVar(&input.NoSession)
@@ -59,6 +64,12 @@ func ConfigureExportCommand(app *kingpin.Application, a *AwsVault) {
 	cmd.Flag("stdout", "Print the SSO link to the terminal without automatically opening the browser").
 		BoolVar(&input.UseStdout)
 
+	cmd.Flag("nested", "Allow running in an aws-vault subshell, ignoring the environment it set").
+		BoolVar(&input.Nested)
+
+	configureSessionPolicyFlags(cmd, &input.Config)
+	configureRoleHopsFlags(cmd, &input.RoleHops)
+
 	cmd.Arg("profile", "Name of the profile").
 		Required().
 		HintAction(a.MustGetProfileNames).
@@ -70,6 +81,12 @@ func ConfigureExportCommand(app *kingpin.Application, a *AwsVault) {
 		input.Config.AssumeRoleDuration = input.SessionDuration
 		input.Config.SSOUseStdout = input.UseStdout
 
+		if input.Nested {
+			if err = leaveSubshellEnv(); err != nil {
+				return err
+			}
+		}
+
 		f, err := a.AwsConfigFile()
 		if err != nil {
 			return err
@@ -79,40 +96,51 @@ func ConfigureExportCommand(app *kingpin.Application, a *AwsVault) {
 			return err
 		}
 
-		err = ExportCommand(input, f, keyring)
+		err = ExportCommand(context.Background(), input, f, keyring)
 		app.FatalIfError(err, "exec")
 		return nil
 	})
 }
 
-func ExportCommand(input ExportCommandInput, f *vault.ConfigFile, keyring keyring.Keyring) error {
-	if os.Getenv("AWS_VAULT") != "" {
-		return fmt.Errorf("in an existing aws-vault subshell; 'exit' from the subshell or unset AWS_VAULT to force")
+func ExportCommand(ctx context.Context, input ExportCommandInput, f *vault.ConfigFile, keyring keyring.Keyring) error {
+	if inSubshell() && !input.Nested {
+		return fmt.Errorf("in an existing aws-vault subshell; 'exit' from the subshell, or use --nested to export another profile's credentials")
+	}
+
+	if err := input.RoleHops.validate(); err != nil {
+		return err
+	}
+	if err := input.RoleHops.takeSessionPolicies(&input.Config); err != nil {
+		return err
 	}
 
 	config, err := vault.NewConfigLoader(input.Config, f, input.ProfileName).GetProfileConfig(input.ProfileName)
 	if err != nil {
 		return fmt.Errorf("Error loading config: %w", err)
 	}
+	if err := validateSessionPolicies(config); err != nil {
+		return err
+	}
 
 	ckr := &vault.CredentialKeyring{Keyring: keyring}
 	credsProvider, err := vault.NewTempCredentialsProvider(config, ckr, input.NoSession, false)
 	if err != nil {
 		return fmt.Errorf("Error getting temporary credentials: %w", err)
 	}
+	credsProvider = input.RoleHops.wrap(credsProvider, config)
 
 	if input.Format == FormatTypeExportJSON {
-		return printJSON(input, credsProvider)
+		return printJSON(ctx, input, credsProvider)
 	} else if input.Format == FormatTypeExportINI {
-		return printINI(credsProvider, input.ProfileName, config.Region)
+		return printINI(ctx, credsProvider, input.ProfileName, config.Region)
 	} else if input.Format == FormatTypeExportEnv {
-		return printEnv(input, credsProvider, config.Region, "export ")
+		return printEnv(ctx, input, credsProvider, config.Region, "export ")
 	} else {
-		return printEnv(input, credsProvider, config.Region, "")
+		return printEnv(ctx, input, credsProvider, config.Region, "")
 	}
 }
 
-func printJSON(input ExportCommandInput, credsProvider aws.CredentialsProvider) error {
+func printJSON(ctx context.Context, input ExportCommandInput, credsProvider aws.CredentialsProvider) error {
 	// AwsCredentialHelperData is metadata for AWS CLI credential process
 	// See https://docs.aws.amazon.com/cli/latest/topic/config-vars.html#sourcing-credentials-from-external-processes
 	type AwsCredentialHelperData struct {
@@ -123,7 +151,7 @@ func printJSON(input ExportCommandInput, credsProvider aws.CredentialsProvider)
 		Expiration      string `json:"Expiration,omitempty"`
 	}
 
-	creds, err := credsProvider.Retrieve(context.TODO())
+	creds, err := credsProvider.Retrieve(ctx)
 	if err != nil {
 		return fmt.Errorf("Failed to get credentials for %s: %w", input.ProfileName, err)
 	}
@@ -158,8 +186,8 @@ func mustNewKey(s *ini.Section, name, val string) {
 	}
 }
 
-func printINI(credsProvider aws.CredentialsProvider, profilename, region string) error {
-	creds, err := credsProvider.Retrieve(context.TODO())
+func printINI(ctx context.Context, credsProvider aws.CredentialsProvider, profilename, region string) error {
+	creds, err := credsProvider.Retrieve(ctx)
 	if err != nil {
 		return fmt.Errorf("Failed to get credentials for %s: %w", profilename, err)
 	}
@@ -186,8 +214,8 @@ func printINI(credsProvider aws.CredentialsProvider, profilename, region string)
 	return nil
 }
 
-func printEnv(input ExportCommandInput, credsProvider aws.CredentialsProvider, region, prefix string) error {
-	creds, err := credsProvider.Retrieve(context.TODO())
+func printEnv(ctx context.Context, input ExportCommandInput, credsProvider aws.CredentialsProvider, region, prefix string) error {
+	creds, err := credsProvider.Retrieve(ctx)
 	if err != nil {
 		return fmt.Errorf("Failed to get credentials for %s: %w", input.ProfileName, err)
 	}
@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -16,6 +17,7 @@ type ListCommandInput struct {
 	OnlyProfiles    bool
 	OnlySessions    bool
 	OnlyCredentials bool
+	OnlyUsable      bool
 }
 
 func ConfigureListCommand(app *kingpin.Application, a *AwsVault) {
@@ -33,6 +35,9 @@ func ConfigureListCommand(app *kingpin.Application, a *AwsVault) {
 	cmd.Flag("credentials", "Show only the profiles with stored credential").
 		BoolVar(&input.OnlyCredentials)
 
+	cmd.Flag("usable", "Show only the profiles with unexpired sessions, which can be used without reaching AWS").
+		BoolVar(&input.OnlyUsable)
+
 	cmd.Action(func(c *kingpin.ParseContext) (err error) {
 		keyring, err := a.Keyring()
 		if err != nil {
@@ -74,6 +79,18 @@ func sessionLabel(sess vault.SessionMetadata) string {
 	return fmt.Sprintf("%s:%s", sess.Type, time.Until(sess.Expiration).Truncate(time.Second))
 }
 
+// usableProfiles returns the profiles that have sessions which haven't expired, in alphabetical order
+func usableProfiles(sessions []vault.SessionMetadata) []string {
+	profileNames := []string{}
+	for _, sess := range sessions {
+		if time.Now().Before(sess.Expiration) && !stringslice(profileNames).has(sess.ProfileName) {
+			profileNames = append(profileNames, sess.ProfileName)
+		}
+	}
+	sort.Strings(profileNames)
+	return profileNames
+}
+
 func ListCommand(input ListCommandInput, awsConfigFile *vault.ConfigFile, keyring keyring.Keyring) (err error) {
 	credentialKeyring := &vault.CredentialKeyring{Keyring: keyring}
 	oidcTokenKeyring := &vault.OIDCTokenKeyring{Keyring: credentialKeyring.Keyring}
@@ -123,6 +140,13 @@ func ListCommand(input ListCommandInput, awsConfigFile *vault.ConfigFile, keyrin
 		return nil
 	}
 
+	if input.OnlyUsable {
+		for _, profileName := range usableProfiles(sessions) {
+			fmt.Println(profileName)
+		}
+		return nil
+	}
+
 	displayedSessionLabels := []string{}
 
 	w := tabwriter.NewWriter(os.Stdout, 25, 4, 2, ' ', 0)
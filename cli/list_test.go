@@ -20,3 +20,19 @@ func ExampleListCommand() {
 	// Output:
 	// llamas
 }
+
+func ExampleListCommand_usable() {
+	app := kingpin.New("aws-vault", "")
+	awsVault := ConfigureGlobals(app)
+	awsVault.keyringImpl = keyring.NewArrayKeyring([]keyring.Item{
+		{Key: "sts.GetSessionToken,bGxhbWFz,,4102444800", Data: []byte(`{}`)},
+		{Key: "sts.GetSessionToken,YWxwYWNhcw,,1572281751", Data: []byte(`{}`)},
+	})
+	ConfigureListCommand(app, awsVault)
+	kingpin.MustParse(app.Parse([]string{
+		"list", "--usable",
+	}))
+
+	// Output:
+	// llamas
+}
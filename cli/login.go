@@ -28,6 +28,7 @@ type LoginCommandInput struct {
 	Config          vault.ProfileConfig
 	SessionDuration time.Duration
 	NoSession       bool
+	RoleHops        RoleHopsInput
 }
 
 func ConfigureLoginCommand(app *kingpin.Application, a *AwsVault) {
@@ -57,6 +58,9 @@ func ConfigureLoginCommand(app *kingpin.Application, a *AwsVault) {
 		Short('s').
 		BoolVar(&input.UseStdout)
 
+	configureSessionPolicyFlags(cmd, &input.Config)
+	configureRoleHopsFlags(cmd, &input.RoleHops)
+
 	cmd.Arg("profile", "Name of the profile. If none given, credentials will be sourced from env vars").
 		HintAction(a.MustGetProfileNames).
 		StringVar(&input.ProfileName)
@@ -96,11 +100,14 @@ func getCredsProvider(input LoginCommandInput, config *vault.ProfileConfig, keyr
 		credsProvider = credentials.StaticCredentialsProvider{Value: configFromEnv.Credentials}
 	} else {
 		// Use a profile from the AWS config file
+		// GetSessionToken credentials can't be used to log in, but they can assume the roles given on the command line
 		ckr := &vault.CredentialKeyring{Keyring: keyring}
 		t := vault.TempCredentialsCreator{
-			Keyring:                   ckr,
-			DisableSessions:           input.NoSession,
-			DisableSessionsForProfile: config.ProfileName,
+			Keyring:         ckr,
+			DisableSessions: input.NoSession,
+		}
+		if len(input.RoleHops.roleARNs()) == 0 {
+			t.DisableSessionsForProfile = config.ProfileName
 		}
 		credsProvider, err = t.GetProviderForProfile(config)
 		if err != nil {
@@ -108,51 +115,32 @@ func getCredsProvider(input LoginCommandInput, config *vault.ProfileConfig, keyr
 		}
 	}
 
-	return credsProvider, err
+	return input.RoleHops.wrap(credsProvider, config), err
 }
 
 // LoginCommand creates a login URL for the AWS Management Console using the method described at
 // https://docs.aws.amazon.com/IAM/latest/UserGuide/id_roles_providers_enable-console-custom-url.html
 func LoginCommand(ctx context.Context, input LoginCommandInput, f *vault.ConfigFile, keyring keyring.Keyring) error {
+	if err := input.RoleHops.validate(); err != nil {
+		return err
+	}
+	if err := input.RoleHops.takeSessionPolicies(&input.Config); err != nil {
+		return err
+	}
+
 	config, err := vault.NewConfigLoader(input.Config, f, input.ProfileName).GetProfileConfig(input.ProfileName)
 	if err != nil {
 		return fmt.Errorf("Error loading config: %w", err)
 	}
-
-	credsProvider, err := getCredsProvider(input, config, keyring)
-	if err != nil {
-		return err
+	if config.HasSessionPolicies() && !config.CanUseSessionPolicies() && !canUseFederationToken(config) {
+		return fmt.Errorf("profile %s: session policies can only be used with profiles that assume a role with role_arn, or that log in with IAM user credentials", config.ProfileName)
 	}
 
-	// if we already know the type of credentials being created, avoid calling isCallerIdentityAssumedRole
-	canCredsBeUsedInLoginURL, err := canProviderBeUsedForLogin(credsProvider)
+	credsProvider, err := loginCredsProvider(ctx, input, config, keyring)
 	if err != nil {
 		return err
 	}
 
-	if !canCredsBeUsedInLoginURL {
-		// use a static creds provider so that we don't request credentials from AWS more than once
-		credsProvider, err = createStaticCredentialsProvider(ctx, credsProvider)
-		if err != nil {
-			return err
-		}
-
-		// if the credentials have come from an unknown source like credential_process, check the
-		// caller identity to see if it's an assumed role
-		isAssumedRole, err := isCallerIdentityAssumedRole(ctx, credsProvider, config)
-		if err != nil {
-			return err
-		}
-
-		if !isAssumedRole {
-			log.Println("Creating a federated session")
-			credsProvider, err = vault.NewFederationTokenProvider(ctx, credsProvider, config)
-			if err != nil {
-				return err
-			}
-		}
-	}
-
 	creds, err := credsProvider.Retrieve(ctx)
 	if err != nil {
 		return err
@@ -163,7 +151,7 @@ func LoginCommand(ctx context.Context, input LoginCommandInput, f *vault.ConfigF
 	}
 
 	loginURLPrefix, destination := generateLoginURL(config.Region, input.Path)
-	signinToken, err := requestSigninToken(ctx, creds, loginURLPrefix)
+	signinToken, err := requestSigninToken(ctx, config.HTTPClient(), creds, loginURLPrefix)
 	if err != nil {
 		return err
 	}
@@ -180,6 +168,49 @@ func LoginCommand(ctx context.Context, input LoginCommandInput, f *vault.ConfigF
 	return nil
 }
 
+// loginCredsProvider returns a provider for credentials that can be used to sign in to the console, creating a
+// federated session when the profile's credentials can't be used directly
+func loginCredsProvider(ctx context.Context, input LoginCommandInput, config *vault.ProfileConfig, keyring keyring.Keyring) (aws.CredentialsProvider, error) {
+	credsProvider, err := getCredsProvider(input, config, keyring)
+	if err != nil {
+		return nil, err
+	}
+
+	// if we already know the type of credentials being created, avoid calling isCallerIdentityAssumedRole
+	canCredsBeUsedInLoginURL, err := canProviderBeUsedForLogin(credsProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	if !canCredsBeUsedInLoginURL {
+		// use a static creds provider so that we don't request credentials from AWS more than once
+		credsProvider, err = createStaticCredentialsProvider(ctx, credsProvider)
+		if err != nil {
+			return nil, err
+		}
+
+		// if the credentials have come from an unknown source like credential_process, check the
+		// caller identity to see if it's an assumed role
+		isAssumedRole, err := isCallerIdentityAssumedRole(ctx, credsProvider, config)
+		if err != nil {
+			return nil, err
+		}
+
+		if isAssumedRole && config.HasSessionPolicies() {
+			return nil, fmt.Errorf("profile %s: session policies can't be applied to the assumed role credentials of the profile", config.ProfileName)
+		}
+		if !isAssumedRole {
+			log.Println("Creating a federated session")
+			credsProvider, err = vault.NewFederationTokenProvider(ctx, credsProvider, config)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return credsProvider, nil
+}
+
 func generateLoginURL(region string, path string) (string, string) {
 	loginURLPrefix := "https://signin.aws.amazon.com/federation"
 	destination := "https://console.aws.amazon.com/"
@@ -206,7 +237,7 @@ func generateLoginURL(region string, path string) (string, string) {
 }
 
 func isCallerIdentityAssumedRole(ctx context.Context, credsProvider aws.CredentialsProvider, config *vault.ProfileConfig) (bool, error) {
-	cfg := vault.NewAwsConfigWithCredsProvider(credsProvider, config.Region, config.STSRegionalEndpoints)
+	cfg := vault.NewAwsConfigWithCredsProvider(credsProvider, config.Region, config)
 	client := sts.NewFromConfig(cfg)
 	id, err := client.GetCallerIdentity(ctx, nil)
 	if err != nil {
@@ -231,6 +262,13 @@ func createStaticCredentialsProvider(ctx context.Context, credsProvider aws.Cred
 	return credentials.StaticCredentialsProvider{Value: creds}, nil
 }
 
+// canUseFederationToken returns true if the profile doesn't get role credentials, so login creates a federated session
+// with GetFederationToken, which accepts session policies
+func canUseFederationToken(config *vault.ProfileConfig) bool {
+	return !config.HasRole() && !config.HasSAML() && !config.HasSSOStartURL() && !config.HasWebIdentity() &&
+		!config.HasAssumeRoot() && !config.HasRolesAnywhere() && !config.HasCognitoIdentityPool()
+}
+
 // canProviderBeUsedForLogin returns true if the credentials produced by the provider is known to be usable by the login URL endpoint
 func canProviderBeUsedForLogin(credsProvider aws.CredentialsProvider) (bool, error) {
 	if _, ok := credsProvider.(*vault.AssumeRoleProvider); ok {
@@ -242,6 +280,9 @@ func canProviderBeUsedForLogin(credsProvider aws.CredentialsProvider) (bool, err
 	if _, ok := credsProvider.(*vault.AssumeRoleWithWebIdentityProvider); ok {
 		return true, nil
 	}
+	if _, ok := credsProvider.(*vault.AssumeRoleWithSAMLProvider); ok {
+		return true, nil
+	}
 	if c, ok := credsProvider.(*vault.CachedSessionProvider); ok {
 		return canProviderBeUsedForLogin(c.SessionProvider)
 	}
@@ -250,7 +291,7 @@ func canProviderBeUsedForLogin(credsProvider aws.CredentialsProvider) (bool, err
 }
 
 // Create a signin token
-func requestSigninToken(ctx context.Context, creds aws.Credentials, loginURLPrefix string) (string, error) {
+func requestSigninToken(ctx context.Context, client *http.Client, creds aws.Credentials, loginURLPrefix string) (string, error) {
 	jsonSession, err := json.Marshal(map[string]string{
 		"sessionId":    creds.AccessKeyID,
 		"sessionKey":   creds.SecretAccessKey,
@@ -270,7 +311,7 @@ func requestSigninToken(ctx context.Context, creds aws.Credentials, loginURLPref
 	q.Add("Session", string(jsonSession))
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/99designs/aws-vault/v7/vault"
+	"github.com/99designs/keyring"
+)
+
+func TestLoginFederationTokenSessionPolicies(t *testing.T) {
+	t.Setenv("AWS_CA_BUNDLE", "")
+
+	var federationPolicyARN string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		switch r.Form.Get("Action") {
+		case "GetCallerIdentity":
+			fmt.Fprint(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><GetCallerIdentityResult>
+  <Arn>arn:aws:iam::111111111111:user/llamas</Arn><UserId>AIDALLAMAS</UserId><Account>111111111111</Account>
+</GetCallerIdentityResult></GetCallerIdentityResponse>`)
+		case "GetUser":
+			fmt.Fprint(w, `<GetUserResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/"><GetUserResult><User>
+  <UserName>llamas</UserName><Arn>arn:aws:iam::111111111111:user/llamas</Arn><UserId>AIDALLAMAS</UserId><Path>/</Path><CreateDate>2020-01-01T00:00:00Z</CreateDate>
+</User></GetUserResult></GetUserResponse>`)
+		case "GetFederationToken":
+			federationPolicyARN = r.Form.Get("PolicyArns.member.1.arn")
+			fmt.Fprintf(w, `<GetFederationTokenResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><GetFederationTokenResult><Credentials>
+  <AccessKeyId>ASIAFEDERATED</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>%s</Expiration>
+</Credentials></GetFederationTokenResult></GetFederationTokenResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+		default:
+			t.Errorf("Unexpected request %v", r.Form)
+		}
+	}))
+	defer ts.Close()
+
+	f, err := os.CreateTemp(t.TempDir(), "aws-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(f, "[profile llamas]\nregion = us-east-1\nendpoint_url = %s\n", ts.URL)
+	f.Close()
+	configFile, err := vault.LoadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := LoginCommandInput{ProfileName: "llamas"}
+	input.Config.PolicyARNs = []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}
+	input.Config.GetFederationTokenDuration = time.Hour
+	config, err := vault.NewConfigLoader(input.Config, configFile, input.ProfileName).GetProfileConfig(input.ProfileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !canUseFederationToken(config) {
+		t.Fatal("Expected login to use GetFederationToken for an IAM user profile")
+	}
+
+	kr := keyring.NewArrayKeyring([]keyring.Item{
+		{Key: "llamas", Data: []byte(`{"AccessKeyID":"AKIALLAMAS","SecretAccessKey":"secret"}`)},
+	})
+	credsProvider, err := loginCredsProvider(context.Background(), input, config, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := credsProvider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIAFEDERATED" {
+		t.Fatalf("Expected federated credentials, got %s", creds.AccessKeyID)
+	}
+	if federationPolicyARN != "arn:aws:iam::aws:policy/ReadOnlyAccess" {
+		t.Fatalf("Expected GetFederationToken to use the session policy, got %q", federationPolicyARN)
+	}
+}
+
+func TestRoleHopsTakeSessionPolicies(t *testing.T) {
+	input := RoleHopsInput{Assume: []string{"arn:aws:iam::222222222222:role/Spoke"}}
+	config := vault.ProfileConfig{PolicyARNs: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}}
+	if err := input.takeSessionPolicies(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.HasSessionPolicies() {
+		t.Fatal("Expected the session policies to be removed from the profile")
+	}
+
+	p, ok := input.wrap(nil, &config).(*vault.AssumeRoleProvider)
+	if !ok || len(p.PolicyARNs) != 1 || p.PolicyARNs[0] != "arn:aws:iam::aws:policy/ReadOnlyAccess" {
+		t.Fatalf("Expected the last role to use the session policy, got %v", p)
+	}
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	// stackEnv lists the profiles of the aws-vault subshells the current one is nested in, outermost first
+	stackEnv = "AWS_VAULT_STACK"
+
+	// originalEnv holds the values the subshellEnvVars and the profile's variables had before aws-vault started the subshell
+	originalEnv = "AWS_VAULT_ORIGINAL_ENV"
+
+	// stackSeparator separates profiles in AWS_VAULT_STACK
+	stackSeparator = ":"
+)
+
+// subshellEnvVars are the variables aws-vault sets for a subshell, other than the credentials themselves.
+// A nested aws-vault restores them so they don't leak from the outer profile into the inner one
+var subshellEnvVars = []string{
+	"AWS_REGION",
+	"AWS_DEFAULT_REGION",
+	"AWS_CREDENTIAL_EXPIRATION",
+	"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+	"AWS_CA_BUNDLE",
+	"AWS_SHARED_CREDENTIALS_FILE",
+	"AWS_CONFIG_FILE",
+	"AWS_VAULT_DOCKER_RUN_ARGS",
+	"COMPOSE_FILE",
+}
+
+// subshellCredentialEnvVars are the outer profile's credentials. A nested aws-vault removes them, so they
+// aren't passed to the credential and MFA processes it runs
+var subshellCredentialEnvVars = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_SECURITY_TOKEN",
+}
+
+func inSubshell() bool {
+	return os.Getenv("AWS_VAULT") != ""
+}
+
+// leaveSubshellEnv restores the environment the current aws-vault subshell was started from, so a nested
+// aws-vault loads the same config file and regions as the outer one did
+func leaveSubshellEnv() error {
+	if !inSubshell() {
+		return nil
+	}
+
+	original, err := url.ParseQuery(os.Getenv(originalEnv))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Leaving the aws-vault subshell for %s", os.Getenv("AWS_VAULT"))
+	restore := func(key string) {
+		if value := original.Get(key); value != "" {
+			os.Setenv(key, value)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+	for _, key := range subshellEnvVars {
+		restore(key)
+	}
+	for key := range original {
+		restore(key)
+	}
+	for _, key := range subshellCredentialEnvVars {
+		os.Unsetenv(key)
+	}
+	return nil
+}
+
+// profileStack returns the profiles of the enclosing aws-vault subshells, outermost first
+func profileStack() []string {
+	if !inSubshell() {
+		return nil
+	}
+	if stack := os.Getenv(stackEnv); stack != "" {
+		return strings.Split(stack, stackSeparator)
+	}
+	return []string{os.Getenv("AWS_VAULT")}
+}
+
+// setSubshellEnv records the profile stack, and the original values of the subshellEnvVars and the
+// variables the profile sets, in env
+func setSubshellEnv(env *environ, profileName string, profileEnv map[string]string) {
+	original := url.Values{}
+	for _, key := range subshellEnvVars {
+		if value := os.Getenv(key); value != "" {
+			original.Set(key, value)
+		}
+	}
+	for key := range profileEnv {
+		original.Set(key, os.Getenv(key))
+	}
+	if len(original) > 0 {
+		env.Set(originalEnv, original.Encode())
+	} else {
+		env.Unset(originalEnv)
+	}
+
+	env.Set(stackEnv, strings.Join(append(profileStack(), profileName), stackSeparator))
+}
+
+// subshellHelpMessage describes the subshell, including the chain of profiles when it's nested
+func subshellHelpMessage(shell string, profileName string) string {
+	stack := profileStack()
+	if len(stack) == 0 {
+		return fmt.Sprintf("Starting subshell %s, use `exit` to exit the subshell", shell)
+	}
+	chain := strings.Join(append(stack, profileName), " > ")
+	return fmt.Sprintf("Starting subshell %s for %s, use `exit` to return to %s", shell, chain, stack[len(stack)-1])
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setTestEnv sets the variables for the test, unsetting those with empty values
+func setTestEnv(t *testing.T, vars map[string]string) {
+	t.Helper()
+	for key, value := range vars {
+		t.Setenv(key, value)
+		if value == "" {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// startTestSubshell sets the environment a subshell for the profile would have, as exec does
+func startTestSubshell(t *testing.T, profileName string, profileEnv map[string]string, subshellEnv map[string]string) {
+	t.Helper()
+	env := environ{}
+	setSubshellEnv(&env, profileName, profileEnv)
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		subshellEnv[key] = value
+	}
+	subshellEnv["AWS_VAULT"] = profileName
+	setTestEnv(t, subshellEnv)
+}
+
+func expectEnv(t *testing.T, expected map[string]string) {
+	t.Helper()
+	for key, value := range expected {
+		if actual, ok := os.LookupEnv(key); actual != value || ok != (value != "") {
+			t.Errorf("Expected %s=%q, got %q", key, value, actual)
+		}
+	}
+}
+
+func TestLeaveSubshellEnv(t *testing.T) {
+	original := map[string]string{
+		"AWS_VAULT":                          "",
+		stackEnv:                             "",
+		originalEnv:                          "",
+		"AWS_REGION":                         "eu-west-1",
+		"AWS_DEFAULT_REGION":                 "",
+		"AWS_CA_BUNDLE":                      "/etc/corporate-ca.pem",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI": "",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN":  "",
+		"AWS_SHARED_CREDENTIALS_FILE":        "",
+		"AWS_ACCESS_KEY_ID":                  "",
+		"AWS_SECRET_ACCESS_KEY":              "",
+		"AWS_SESSION_TOKEN":                  "",
+		"TF_VAR_environment":                 "",
+	}
+	setTestEnv(t, original)
+
+	startTestSubshell(t, "management", map[string]string{"TF_VAR_environment": "management"}, map[string]string{
+		"AWS_REGION":                         "us-east-1",
+		"AWS_DEFAULT_REGION":                 "us-east-1",
+		"AWS_CA_BUNDLE":                      "/tmp/aws-vault-tls/ca-bundle.pem",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI": "https://127.0.0.1:1234",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN":  "secret-token",
+		"AWS_SHARED_CREDENTIALS_FILE":        "/tmp/aws-vault-creds/credentials",
+		"AWS_ACCESS_KEY_ID":                  "ASIAMANAGEMENT",
+		"AWS_SECRET_ACCESS_KEY":              "secret",
+		"AWS_SESSION_TOKEN":                  "token",
+		"TF_VAR_environment":                 "management",
+	})
+
+	if err := leaveSubshellEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(original, "AWS_VAULT")
+	delete(original, stackEnv)
+	delete(original, originalEnv)
+	expectEnv(t, original)
+}
+
+func TestNestedSubshells(t *testing.T) {
+	original := map[string]string{
+		"AWS_VAULT":                          "",
+		stackEnv:                             "",
+		originalEnv:                          "",
+		"AWS_REGION":                         "eu-west-1",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI": "",
+		"AWS_ACCESS_KEY_ID":                  "",
+	}
+	setTestEnv(t, original)
+
+	if stack := profileStack(); stack != nil {
+		t.Fatalf("Expected no profile stack outside a subshell, got %v", stack)
+	}
+
+	profiles := []string{"management", "workload", "sandbox"}
+	regions := []string{"us-east-1", "us-west-2", "ap-southeast-2"}
+	for i, profileName := range profiles {
+		if err := leaveSubshellEnv(); err != nil {
+			t.Fatal(err)
+		}
+		expectEnv(t, map[string]string{
+			"AWS_REGION":                         "eu-west-1",
+			"AWS_CONTAINER_CREDENTIALS_FULL_URI": "",
+			"AWS_ACCESS_KEY_ID":                  "",
+		})
+
+		startTestSubshell(t, profileName, nil, map[string]string{
+			"AWS_REGION":                         regions[i],
+			"AWS_CONTAINER_CREDENTIALS_FULL_URI": "http://127.0.0.1:" + regions[i],
+			"AWS_ACCESS_KEY_ID":                  "ASIA" + strings.ToUpper(profileName),
+		})
+
+		if stack := profileStack(); !reflect.DeepEqual(stack, profiles[:i+1]) {
+			t.Fatalf("Expected the profile stack %v, got %v", profiles[:i+1], stack)
+		}
+	}
+
+	expected := "Starting subshell /bin/sh for management > workload > sandbox > dev, use `exit` to return to sandbox"
+	if msg := subshellHelpMessage("/bin/sh", "dev"); msg != expected {
+		t.Fatalf("Expected %q, got %q", expected, msg)
+	}
+}
+
+func TestProfileStackWithoutStackEnv(t *testing.T) {
+	setTestEnv(t, map[string]string{"AWS_VAULT": "management", stackEnv: ""})
+
+	if stack := profileStack(); !reflect.DeepEqual(stack, []string{"management"}) {
+		t.Fatalf("Expected the subshell's profile, got %v", stack)
+	}
+}
@@ -13,22 +13,25 @@ func ConfigureProxyCommand(app *kingpin.Application) {
 	stop := false
 
 	cmd := app.Command("proxy", "Start a proxy for the ec2 instance role server locally.").
-		Alias("server").
 		Hidden()
 
 	cmd.Flag("stop", "Stop the proxy").
 		BoolVar(&stop)
 
 	cmd.Action(func(*kingpin.ParseContext) error {
-		if stop {
-			server.StopProxy()
-			return nil
-		}
-		handleSigTerm()
-		return server.StartProxy()
+		return runProxy(stop)
 	})
 }
 
+func runProxy(stop bool) error {
+	if stop {
+		server.StopProxy()
+		return nil
+	}
+	handleSigTerm()
+	return server.StartProxy()
+}
+
 func handleSigTerm() {
 	// shutdown
 	c := make(chan os.Signal, 1)
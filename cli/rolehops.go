@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/99designs/aws-vault/v7/vault"
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// RoleHopsInput are roles to assume on top of the profile's credentials, given on the command line
+type RoleHopsInput struct {
+	RoleARN         string
+	Assume          []string
+	ExternalID      string
+	RoleSessionName string
+	Tags            map[string]string
+	PolicyARNs      []string
+	Policy          string
+}
+
+func configureRoleHopsFlags(cmd *kingpin.CmdClause, input *RoleHopsInput) {
+	input.Tags = map[string]string{}
+
+	cmd.Flag("role-arn", "ARN of a role to assume with the profile's credentials, before any roles given with --assume").
+		StringVar(&input.RoleARN)
+
+	cmd.Flag("assume", "ARN of a role to assume with the credentials of the previous role, or of the profile. Can be repeated to chain roles").
+		StringsVar(&input.Assume)
+
+	cmd.Flag("external-id", "External ID to use when assuming the last role given with --role-arn or --assume").
+		StringVar(&input.ExternalID)
+
+	cmd.Flag("role-session-name", "Session name to use when assuming the last role given with --role-arn or --assume").
+		StringVar(&input.RoleSessionName)
+
+	cmd.Flag("tag", "Session tag KEY=VALUE to use when assuming the last role given with --role-arn or --assume. Can be repeated").
+		StringMapVar(&input.Tags)
+}
+
+// roleARNs returns the roles to assume in order, starting with --role-arn
+func (input RoleHopsInput) roleARNs() []string {
+	if input.RoleARN == "" {
+		return input.Assume
+	}
+	return append([]string{input.RoleARN}, input.Assume...)
+}
+
+// takeSessionPolicies moves the session policies given on the command line from config to the last role, as
+// they limit the credentials that are returned rather than the profile's own role
+func (input *RoleHopsInput) takeSessionPolicies(config *vault.ProfileConfig) error {
+	if len(input.roleARNs()) == 0 {
+		return nil
+	}
+
+	input.PolicyARNs = config.PolicyARNs
+	input.Policy = config.Policy
+	if config.PolicyFile != "" && input.Policy == "" {
+		b, err := os.ReadFile(config.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("Failed to read policy file: %w", err)
+		}
+		input.Policy = string(b)
+	}
+	config.PolicyARNs = nil
+	config.Policy = ""
+	config.PolicyFile = ""
+
+	return nil
+}
+
+func (input RoleHopsInput) validate() error {
+	if len(input.roleARNs()) == 0 && (input.ExternalID != "" || input.RoleSessionName != "" || len(input.Tags) > 0) {
+		return fmt.Errorf("--external-id, --role-session-name and --tag need a role given with --role-arn or --assume")
+	}
+	return nil
+}
+
+// wrap returns a provider that assumes the roles in turn on top of credsProvider, or credsProvider if there are none
+func (input RoleHopsInput) wrap(credsProvider aws.CredentialsProvider, config *vault.ProfileConfig) aws.CredentialsProvider {
+	roleARNs := input.roleARNs()
+	if len(roleARNs) == 0 {
+		return credsProvider
+	}
+
+	hops := make([]vault.RoleHop, len(roleARNs))
+	for i, roleARN := range roleARNs {
+		hops[i].RoleARN = roleARN
+	}
+	last := &hops[len(hops)-1]
+	last.ExternalID = input.ExternalID
+	last.RoleSessionName = input.RoleSessionName
+	if len(input.Tags) > 0 {
+		last.SessionTags = input.Tags
+	}
+	last.PolicyARNs = input.PolicyARNs
+	last.Policy = input.Policy
+
+	return vault.NewRoleHopsProvider(credsProvider, config, hops)
+}
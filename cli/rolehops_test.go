@@ -0,0 +1,14 @@
+package cli
+
+import "fmt"
+
+func Example_roleHopsOrder() {
+	input := RoleHopsInput{
+		Assume:  []string{"arn:aws:iam::222222222222:role/Spoke"},
+		RoleARN: "arn:aws:iam::111111111111:role/Hub",
+	}
+	fmt.Println(input.roleARNs())
+
+	// Output:
+	// [arn:aws:iam::111111111111:role/Hub arn:aws:iam::222222222222:role/Spoke]
+}
@@ -44,13 +44,13 @@ func ConfigureRotateCommand(app *kingpin.Application, a *AwsVault) {
 			return err
 		}
 
-		err = RotateCommand(input, f, keyring)
+		err = RotateCommand(context.Background(), input, f, keyring)
 		app.FatalIfError(err, "rotate")
 		return nil
 	})
 }
 
-func RotateCommand(input RotateCommandInput, f *vault.ConfigFile, keyring keyring.Keyring) error {
+func RotateCommand(ctx context.Context, input RotateCommandInput, f *vault.ConfigFile, keyring keyring.Keyring) error {
 	configLoader := vault.NewConfigLoader(input.Config, f, input.ProfileName)
 	config, err := configLoader.GetProfileConfig(input.ProfileName)
 	if err != nil {
@@ -70,7 +70,7 @@ func RotateCommand(input RotateCommandInput, f *vault.ConfigFile, keyring keyrin
 	}
 
 	// Get the existing credentials access key ID
-	oldMasterCreds, err := vault.NewMasterCredentialsProvider(ckr, masterCredentialsName).Retrieve(context.TODO())
+	oldMasterCreds, err := vault.NewMasterCredentialsProvider(ckr, masterCredentialsName).Retrieve(ctx)
 	if err != nil {
 		return fmt.Errorf("Error loading source credentials for '%s': %w", masterCredentialsName, err)
 	}
@@ -91,17 +91,17 @@ func RotateCommand(input RotateCommandInput, f *vault.ConfigFile, keyring keyrin
 		}
 	}
 
-	cfg := vault.NewAwsConfigWithCredsProvider(credsProvider, config.Region, config.STSRegionalEndpoints)
+	cfg := vault.NewAwsConfigWithCredsProvider(credsProvider, config.Region, config)
 
 	// A username is needed for some IAM calls if the credentials have assumed a role
-	iamUserName, err := getUsernameIfAssumingRole(context.TODO(), cfg, config)
+	iamUserName, err := getUsernameIfAssumingRole(ctx, cfg, config)
 	if err != nil {
 		return err
 	}
 
 	iamClient := iam.NewFromConfig(cfg)
 	// Create a new access key
-	createOut, err := iamClient.CreateAccessKey(context.TODO(), &iam.CreateAccessKeyInput{
+	createOut, err := iamClient.CreateAccessKey(ctx, &iam.CreateAccessKeyInput{
 		UserName: iamUserName,
 	})
 	if err != nil {
@@ -131,7 +131,7 @@ func RotateCommand(input RotateCommandInput, f *vault.ConfigFile, keyring keyrin
 	// Use new credentials to delete old access key
 	fmt.Printf("Deleting old access key %s\n", oldMasterCredsAccessKeyID)
 	err = retry(time.Second*20, time.Second*2, func() error {
-		_, err = iamClient.DeleteAccessKey(context.TODO(), &iam.DeleteAccessKeyInput{
+		_, err = iamClient.DeleteAccessKey(ctx, &iam.DeleteAccessKeyInput{
 			AccessKeyId: &oldMasterCreds.AccessKeyID,
 			UserName:    iamUserName,
 		})
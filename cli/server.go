@@ -0,0 +1,388 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/99designs/aws-vault/v7/server"
+	"github.com/99designs/aws-vault/v7/vault"
+	"github.com/99designs/keyring"
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// serverShutdownTimeout is how long the server waits for active requests when shutting down
+const serverShutdownTimeout = 5 * time.Second
+
+type ServerCommandInput struct {
+	ProfileName      string
+	Port             int
+	TokenFile        string
+	TokenRotation    time.Duration
+	Clients          map[string]string
+	AuditLog         string
+	EcsListen        EcsListenInput
+	TLSDir           string
+	StartEc2Server   bool
+	IMDSv2Only       bool
+	Lazy             bool
+	AllowProfiles    []string
+	AllowExecutables []string
+	Config           vault.ProfileConfig
+	SessionDuration  time.Duration
+	UseStdout        bool
+}
+
+func ConfigureServerCommand(app *kingpin.Application, a *AwsVault) {
+	input := ServerCommandInput{
+		Clients: map[string]string{},
+	}
+
+	cmd := app.Command("server", "Run a credential server in the foreground for other processes to share.")
+
+	// --profile isn't required by the parser, so `aws-vault server` without it can still start the EC2 metadata
+	// proxy, as it did when it was an alias of `aws-vault proxy`
+	cmd.Flag("profile", "Name of the profile. Required").
+		Short('p').
+		HintAction(a.MustGetProfileNames).
+		StringVar(&input.ProfileName)
+
+	stopProxy := false
+	cmd.Flag("stop", "Deprecated, use `aws-vault proxy --stop`").
+		Hidden().
+		BoolVar(&stopProxy)
+
+	cmd.Flag("port", "Port for the ECS credential server to listen on. Defaults to a random port").
+		IntVar(&input.Port)
+
+	cmd.Flag("token-file", "File containing the authorization token clients must use. Created with a new token if it doesn't exist").
+		StringVar(&input.TokenFile)
+
+	cmd.Flag("token-rotation", "How often to rotate the tokens in --token-file and --client files. Previous tokens are accepted for up to 5 minutes. Defaults to no rotation").
+		DurationVar(&input.TokenRotation)
+
+	cmd.Flag("client", "Add a named client with its own token, written to a file. Can be repeated").
+		PlaceHolder("NAME=PATH").
+		StringMapVar(&input.Clients)
+
+	cmd.Flag("audit-log", "Append a line of JSON to this file for each request, with the name of the client that made it").
+		StringVar(&input.AuditLog)
+
+	configureEcsListenFlags(cmd, &input.EcsListen, "")
+
+	cmd.Flag("tls-dir", "With --tls, keep the local CA in this directory so clients keep trusting it across restarts. Defaults to a temporary directory").
+		StringVar(&input.TLSDir)
+
+	cmd.Flag("ec2-server", "Also run a EC2 metadata server for credentials").
+		BoolVar(&input.StartEc2Server)
+
+	cmd.Flag("imdsv2-only", "When using --ec2-server, reject IMDSv1 requests that don't have a session token").
+		BoolVar(&input.IMDSv2Only)
+
+	cmd.Flag("lazy", "Lazily fetch credentials").
+		BoolVar(&input.Lazy)
+
+	cmd.Flag("allow-profile", "Also serve credentials for these profiles on /profile/<name>. Can be comma-separated or repeated").
+		HintAction(a.MustGetProfileNames).
+		StringsVar(&input.AllowProfiles)
+
+	cmd.Flag("allow-exe", "Only serve credentials to processes whose executable matches this pattern. Can be repeated. Linux only").
+		StringsVar(&input.AllowExecutables)
+
+	cmd.Flag("duration", "Duration of the temporary or assume-role session. Defaults to 1h").
+		Short('d').
+		DurationVar(&input.SessionDuration)
+
+	cmd.Flag("region", "The AWS region").
+		StringVar(&input.Config.Region)
+
+	cmd.Flag("stdout", "Print the SSO link to the terminal without automatically opening the browser").
+		BoolVar(&input.UseStdout)
+
+	configureSessionPolicyFlags(cmd, &input.Config)
+
+	cmd.Action(func(c *kingpin.ParseContext) (err error) {
+		if input.ProfileName == "" {
+			if hasServerFlags(cmd, c, "stop") {
+				return fmt.Errorf("required flag --profile not provided")
+			}
+			fmt.Fprintf(os.Stderr, "aws-vault: warning: running `aws-vault server` without --profile to start the EC2 metadata proxy is deprecated, use `aws-vault proxy` instead\n")
+			return runProxy(stopProxy)
+		}
+		if stopProxy {
+			return fmt.Errorf("--stop can't be used with --profile, use `aws-vault proxy --stop` to stop the EC2 metadata proxy")
+		}
+
+		input.Config.MfaPromptMethod = a.PromptDriver(false)
+		input.Config.NonChainedGetSessionTokenDuration = input.SessionDuration
+		input.Config.AssumeRoleDuration = input.SessionDuration
+		input.Config.SSOUseStdout = input.UseStdout
+		input.AllowProfiles = splitCommaSeparated(input.AllowProfiles)
+
+		f, err := a.AwsConfigFile()
+		if err != nil {
+			return err
+		}
+		keyring, err := a.Keyring()
+		if err != nil {
+			return err
+		}
+
+		err = ServerCommand(context.Background(), input, f, keyring)
+		app.FatalIfError(err, "server")
+		return nil
+	})
+}
+
+// hasServerFlags reports whether any of the command's own flags other than except were given
+func hasServerFlags(cmd *kingpin.CmdClause, c *kingpin.ParseContext, except string) bool {
+	for _, e := range c.Elements {
+		if f, ok := e.Clause.(*kingpin.FlagClause); ok && cmd.GetFlag(f.Model().Name) == f && f.Model().Name != except {
+			return true
+		}
+	}
+	return false
+}
+
+func ServerCommand(ctx context.Context, input ServerCommandInput, f *vault.ConfigFile, keyring keyring.Keyring) error {
+	if input.IMDSv2Only && !input.StartEc2Server {
+		return fmt.Errorf("--imdsv2-only can only be used with --ec2-server")
+	}
+
+	if input.TokenRotation < 0 {
+		return fmt.Errorf("--token-rotation can't be negative")
+	}
+	if input.TokenRotation > 0 && input.TokenFile == "" && len(input.Clients) == 0 {
+		return fmt.Errorf("--token-rotation can only be used with --token-file or --client")
+	}
+	for name, path := range input.Clients {
+		if name == server.DefaultEcsClientName {
+			return fmt.Errorf("--client name '%s' is reserved for the --token-file client", name)
+		}
+		if path == "" {
+			return fmt.Errorf("--client %s needs a token file path", name)
+		}
+	}
+
+	if len(input.AllowExecutables) > 0 && runtime.GOOS != "linux" {
+		return fmt.Errorf("--allow-exe is only supported on Linux")
+	}
+
+	ckr := &vault.CredentialKeyring{Keyring: keyring}
+
+	config, credsProvider, err := loadServerCredentials(input, f, ckr)
+	if err != nil {
+		return err
+	}
+
+	authToken, err := readTokenFile(input.TokenFile)
+	if err != nil {
+		return err
+	}
+
+	if input.TLSDir != "" && !input.EcsListen.TLS {
+		return fmt.Errorf("--tls-dir can only be used with --tls")
+	}
+	tlsDir := input.TLSDir
+	if input.EcsListen.TLS && tlsDir == "" {
+		if tlsDir, err = os.MkdirTemp("", "aws-vault-tls-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(tlsDir)
+	}
+	listen, err := input.EcsListen.listenOptions(tlsDir)
+	if err != nil {
+		return err
+	}
+	listen.Port = input.Port
+
+	ecsServer, err := server.NewEcsServer(ctx, credsProvider, config, authToken, listen, input.Lazy)
+	if err != nil {
+		return err
+	}
+	allowlist := input.processAllowlist()
+	ecsServer.RestrictProcesses(allowlist)
+	ecsServer.SetSessionKeyring(&vault.SessionKeyring{Keyring: keyring})
+	if len(input.AllowProfiles) > 0 {
+		ecsServer.ServeProfiles(input.Config, f, ckr, input.AllowProfiles)
+	}
+
+	if input.TokenFile != "" {
+		log.Printf("Writing authorization token to %s", input.TokenFile)
+		if err = ecsServer.SetTokenFile(input.TokenFile); err != nil {
+			return err
+		}
+	}
+	for name, path := range input.Clients {
+		log.Printf("Writing authorization token for client %s to %s", name, path)
+		if err = ecsServer.AddClient(name, path); err != nil {
+			return err
+		}
+	}
+	if input.TokenRotation > 0 {
+		ecsServer.RotateTokens(input.TokenRotation)
+	}
+	if input.AuditLog != "" {
+		auditLog, err := os.OpenFile(input.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+		ecsServer.SetAuditLog(auditLog)
+	}
+
+	if input.StartEc2Server {
+		if server.IsProxyRunning() {
+			return fmt.Errorf("Another process is already bound to 169.254.169.254:80")
+		}
+		if err := server.StartEc2EndpointProxyServerProcess(); err != nil {
+			return err
+		}
+		defer server.StopProxy()
+
+		if err = server.StartEc2CredentialsServer(ctx, ecsServer.BaseCredentialsProvider(), config.Region, input.IMDSv2Only, allowlist); err != nil {
+			return fmt.Errorf("Failed to start credential server: %w", err)
+		}
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- ecsServer.Serve()
+	}()
+
+	printServerEnv(ecsServer, config, input)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case err := <-serveErr:
+			if err != http.ErrServerClosed { // ErrServerClosed is a graceful close
+				return fmt.Errorf("ecs server: %w", err)
+			}
+			return nil
+
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				reloadServer(ctx, ecsServer, input, ckr)
+				continue
+			}
+
+			log.Printf("Received %s, shutting down", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+			err := ecsServer.Shutdown(ctx)
+			cancel()
+			return err
+		}
+	}
+}
+
+// processAllowlist returns the processes allowed to request credentials, or nil if any process may
+func (input ServerCommandInput) processAllowlist() *server.ProcessAllowlist {
+	if len(input.AllowExecutables) == 0 {
+		return nil
+	}
+	return &server.ProcessAllowlist{Executables: input.AllowExecutables}
+}
+
+func loadServerCredentials(input ServerCommandInput, f *vault.ConfigFile, ckr *vault.CredentialKeyring) (*vault.ProfileConfig, aws.CredentialsProvider, error) {
+	for _, p := range input.AllowProfiles {
+		if _, ok := f.ProfileSection(p); !ok {
+			return nil, nil, fmt.Errorf("Profile '%s' given to --allow-profile not found in %s", p, f.Path)
+		}
+	}
+
+	config, err := vault.NewConfigLoader(input.Config, f, input.ProfileName).GetProfileConfig(input.ProfileName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error loading config: %w", err)
+	}
+	if err := validateSessionPolicies(config); err != nil {
+		return nil, nil, err
+	}
+
+	credsProvider, err := vault.NewTempCredentialsProvider(config, ckr, false, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error getting temporary credentials: %w", err)
+	}
+
+	return config, credsProvider, nil
+}
+
+// reloadServer re-reads the AWS config file and replaces the server's credentials, keeping the
+// current ones if the new config can't be loaded
+func reloadServer(ctx context.Context, ecsServer *server.EcsServer, input ServerCommandInput, ckr *vault.CredentialKeyring) {
+	log.Println("Reloading config")
+
+	f, err := vault.LoadConfigFromEnv()
+	if err != nil {
+		log.Printf("Failed to reload config: %s", err.Error())
+		return
+	}
+
+	config, credsProvider, err := loadServerCredentials(input, f, ckr)
+	if err != nil {
+		log.Printf("Failed to reload config: %s", err.Error())
+		return
+	}
+
+	if err = ecsServer.Reload(ctx, credsProvider, config, f, input.Lazy); err != nil {
+		log.Printf("Failed to reload credentials: %s", err.Error())
+	}
+}
+
+func readTokenFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+func printServerEnv(ecsServer *server.EcsServer, config *vault.ProfileConfig, input ServerCommandInput) {
+	fmt.Fprintf(os.Stderr, "Serving credentials for profile %s. Clients need the following environment:\n\n", input.ProfileName)
+
+	fmt.Printf("export AWS_CONTAINER_CREDENTIALS_FULL_URI=%s\n", ecsServer.BaseURL())
+	if input.TokenFile != "" {
+		tokenFile, _ := filepath.Abs(input.TokenFile)
+		fmt.Printf("export AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE=%s\n", tokenFile)
+	} else {
+		fmt.Printf("export AWS_CONTAINER_AUTHORIZATION_TOKEN=%s\n", ecsServer.AuthToken())
+	}
+	if ecsServer.CABundlePath() != "" {
+		fmt.Printf("export AWS_CA_BUNDLE=%s\n", ecsServer.CABundlePath())
+	}
+	if config.Region != "" {
+		fmt.Printf("export AWS_REGION=%s\n", config.Region)
+		fmt.Printf("export AWS_DEFAULT_REGION=%s\n", config.Region)
+	}
+
+	fmt.Fprintln(os.Stderr)
+	for _, p := range input.AllowProfiles {
+		fmt.Fprintf(os.Stderr, "Credentials for profile %s are served on %s\n", p, ecsServer.ProfileURL(p))
+	}
+	for name, path := range input.Clients {
+		fmt.Fprintf(os.Stderr, "Client %s uses the token in %s\n", name, path)
+	}
+	if input.StartEc2Server {
+		fmt.Fprintln(os.Stderr, "Credentials are also served on the EC2 metadata endpoint 169.254.169.254")
+	}
+	fmt.Fprintln(os.Stderr, "Send SIGHUP to reload the AWS config file, SIGTERM or Ctrl-C to stop")
+}
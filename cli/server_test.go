@@ -0,0 +1,24 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+func TestServerCommandRequiresProfileWithServerFlags(t *testing.T) {
+	app := kingpin.New("aws-vault", "")
+	awsVault := ConfigureGlobals(app)
+	ConfigureServerCommand(app, awsVault)
+
+	_, err := app.Parse([]string{"server", "--region", "us-east-1"})
+	if err == nil || !strings.Contains(err.Error(), "--profile") {
+		t.Fatalf("Expected an error asking for --profile, got %v", err)
+	}
+
+	_, err = app.Parse([]string{"server", "--profile", "jonsmith", "--stop"})
+	if err == nil || !strings.Contains(err.Error(), "aws-vault proxy --stop") {
+		t.Fatalf("Expected an error for --stop with --profile, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/99designs/aws-vault/v7/vault"
+	"github.com/alecthomas/kingpin/v2"
+)
+
+// sessionPolicyPresets are AWS managed policies that can be applied as session policies with a single flag
+var sessionPolicyPresets = []struct {
+	Flag      string
+	PolicyARN string
+}{
+	{"read-only", "arn:aws:iam::aws:policy/ReadOnlyAccess"},
+	{"view-only", "arn:aws:iam::aws:policy/job-function/ViewOnlyAccess"},
+	{"security-audit", "arn:aws:iam::aws:policy/SecurityAudit"},
+}
+
+func configureSessionPolicyFlags(cmd *kingpin.CmdClause, config *vault.ProfileConfig) {
+	cmd.Flag("policy-arn", "ARN of a managed policy to use as a session policy. Can be specified multiple times").
+		StringsVar(&config.PolicyARNs)
+
+	cmd.Flag("policy-file", "File containing a JSON inline session policy").
+		ExistingFileVar(&config.PolicyFile)
+
+	for _, preset := range sessionPolicyPresets {
+		policyARN := preset.PolicyARN
+		enabled := false
+		cmd.Flag(preset.Flag, fmt.Sprintf("Use %s as a session policy", policyARN)).
+			Action(func(*kingpin.ParseContext) error {
+				if enabled {
+					config.PolicyARNs = append(config.PolicyARNs, policyARN)
+				}
+				return nil
+			}).
+			BoolVar(&enabled)
+	}
+}
+
+func validateSessionPolicies(config *vault.ProfileConfig) error {
+	if config.HasSessionPolicies() && !config.CanUseSessionPolicies() {
+		return fmt.Errorf("profile %s: session policies can only be used with profiles that assume a role with role_arn", config.ProfileName)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+type ShellInitCommandInput struct {
+	Shell string
+}
+
+// shellInitScripts add the active profile and the time left before its credentials expire to the prompt.
+// AWS_CREDENTIAL_EXPIRATION is parsed with GNU date, falling back to BSD date
+var shellInitScripts = map[string]string{
+	"bash": `__aws_vault_prompt() {
+  [ -n "$AWS_VAULT" ] || return 0
+  local left=""
+  if [ -n "$AWS_CREDENTIAL_EXPIRATION" ]; then
+    local expires
+    expires=$(date -d "$AWS_CREDENTIAL_EXPIRATION" +%s 2>/dev/null || date -j -u -f '%Y-%m-%dT%H:%M:%SZ' "$AWS_CREDENTIAL_EXPIRATION" +%s 2>/dev/null)
+    if [ -n "$expires" ]; then
+      local seconds=$(( expires - $(date +%s) ))
+      if [ "$seconds" -le 0 ]; then left=" expired"; else left=" $(( seconds / 60 ))m"; fi
+    fi
+  fi
+  printf '(aws-vault:%s%s) ' "$AWS_VAULT" "$left"
+}
+if [ -z "$__AWS_VAULT_PROMPT" ]; then
+  __AWS_VAULT_PROMPT=1
+  PS1='$(__aws_vault_prompt)'"$PS1"
+fi
+`,
+	"zsh": `zmodload zsh/datetime
+__aws_vault_prompt() {
+  [[ -n "$AWS_VAULT" ]] || return 0
+  local left=""
+  if [[ -n "$AWS_CREDENTIAL_EXPIRATION" ]]; then
+    local expires
+    TZ=UTC strftime -r -s expires '%Y-%m-%dT%H:%M:%SZ' "$AWS_CREDENTIAL_EXPIRATION" 2>/dev/null
+    if [[ -n "$expires" ]]; then
+      local seconds=$(( expires - EPOCHSECONDS ))
+      if (( seconds <= 0 )); then left=" expired"; else left=" $(( seconds / 60 ))m"; fi
+    fi
+  fi
+  print -n "(aws-vault:${AWS_VAULT}${left}) "
+}
+if [[ -z "$__AWS_VAULT_PROMPT" ]]; then
+  __AWS_VAULT_PROMPT=1
+  setopt prompt_subst
+  PROMPT='$(__aws_vault_prompt)'"$PROMPT"
+fi
+`,
+	"fish": `function __aws_vault_prompt
+  test -n "$AWS_VAULT"; or return 0
+  set -l left ""
+  if test -n "$AWS_CREDENTIAL_EXPIRATION"
+    set -l expires (date -d "$AWS_CREDENTIAL_EXPIRATION" +%s 2>/dev/null; or date -j -u -f '%Y-%m-%dT%H:%M:%SZ' "$AWS_CREDENTIAL_EXPIRATION" +%s 2>/dev/null)
+    if test -n "$expires"
+      set -l seconds (math $expires - (date +%s))
+      if test $seconds -le 0
+        set left " expired"
+      else
+        set left " "(math --scale=0 $seconds / 60)"m"
+      end
+    end
+  end
+  printf '(aws-vault:%s%s) ' $AWS_VAULT $left
+end
+if not set -q __AWS_VAULT_PROMPT
+  set -g __AWS_VAULT_PROMPT 1
+  functions -c fish_prompt __aws_vault_original_fish_prompt
+  function fish_prompt
+    __aws_vault_prompt
+    __aws_vault_original_fish_prompt
+  end
+end
+`,
+}
+
+func ConfigureShellInitCommand(app *kingpin.Application) {
+	input := ShellInitCommandInput{}
+
+	cmd := app.Command("shell-init", "Print shell code that shows the active profile and time until its credentials expire in the prompt.")
+
+	cmd.Arg("shell", "The shell to print code for").
+		Required().
+		EnumVar(&input.Shell, "bash", "zsh", "fish")
+
+	cmd.Action(func(c *kingpin.ParseContext) (err error) {
+		err = ShellInitCommand(input)
+		app.FatalIfError(err, "shell-init")
+		return nil
+	})
+}
+
+func ShellInitCommand(input ShellInitCommandInput) error {
+	script, ok := shellInitScripts[input.Shell]
+	if !ok {
+		return fmt.Errorf("Unsupported shell '%s'", input.Shell)
+	}
+	fmt.Print(script)
+	return nil
+}
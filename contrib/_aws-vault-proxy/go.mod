@@ -1,7 +0,0 @@
-module aws-vault-ecs-server-reverse-proxy
-
-go 1.17
-
-require github.com/gorilla/handlers v1.5.1
-
-require github.com/felixge/httpsnoop v1.0.1 // indirect
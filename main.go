@@ -20,10 +20,16 @@ func main() {
 	cli.ConfigureListCommand(app, a)
 	cli.ConfigureRotateCommand(app, a)
 	cli.ConfigureExecCommand(app, a)
+	cli.ConfigureExecEachCommand(app, a)
 	cli.ConfigureExportCommand(app, a)
 	cli.ConfigureClearCommand(app, a)
 	cli.ConfigureLoginCommand(app, a)
+	cli.ConfigureServerCommand(app, a)
+	cli.ConfigureStatusCommand(app)
+	cli.ConfigureRefreshCommand(app)
+	cli.ConfigureShellInitCommand(app)
 	cli.ConfigureProxyCommand(app)
+	cli.ConfigureEc2NamespaceCommand(app)
 
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 }
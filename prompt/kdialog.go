@@ -16,8 +16,20 @@ func KDialogMfaPrompt(mfaSerial string) (string, error) {
 	return strings.TrimSpace(string(out)), nil
 }
 
+func KDialogPassphrasePrompt(name string) (string, error) {
+	cmd := exec.Command("kdialog", "--password", passphrasePromptMessage(name), "--title", "aws-vault")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
 func init() {
 	if _, err := exec.LookPath("kdialog"); err == nil {
 		Methods["kdialog"] = KDialogMfaPrompt
+		PassphraseMethods["kdialog"] = KDialogPassphrasePrompt
 	}
 }
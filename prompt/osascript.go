@@ -21,8 +21,24 @@ func OSAScriptMfaPrompt(mfaSerial string) (string, error) {
 	return strings.TrimSpace(string(out)), nil
 }
 
+func OSAScriptPassphrasePrompt(name string) (string, error) {
+	cmd := exec.Command("osascript", "-e", fmt.Sprintf(`
+		display dialog %q default answer "" with hidden answer buttons {"OK", "Cancel"} default button 1
+        text returned of the result
+        return result`,
+		passphrasePromptMessage(name)))
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
 func init() {
 	if _, err := exec.LookPath("osascript"); err == nil {
 		Methods["osascript"] = OSAScriptMfaPrompt
+		PassphraseMethods["osascript"] = OSAScriptPassphrasePrompt
 	}
 }
@@ -9,6 +9,10 @@ type Func func(string) (string, error)
 
 var Methods = map[string]Func{}
 
+// PassphraseMethods are the prompt methods that can ask for a passphrase without echoing it, called with the name of
+// the file the passphrase is for
+var PassphraseMethods = map[string]Func{}
+
 func Available() []string {
 	methods := []string{}
 	for k := range Methods {
@@ -29,3 +33,7 @@ func Method(s string) Func {
 func mfaPromptMessage(mfaSerial string) string {
 	return fmt.Sprintf("Enter MFA code for %s: ", mfaSerial)
 }
+
+func passphrasePromptMessage(name string) string {
+	return fmt.Sprintf("Enter passphrase for %s: ", name)
+}
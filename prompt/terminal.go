@@ -2,6 +2,7 @@ package prompt
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/mattn/go-tty"
@@ -41,10 +42,41 @@ func TerminalSecretPrompt(message string) (string, error) {
 	return strings.TrimSpace(text), nil
 }
 
+// TerminalChoicePrompt lists the choices on the terminal and returns the index of the one chosen
+func TerminalChoicePrompt(message string, choices []string) (int, error) {
+	tty, err := tty.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer tty.Close()
+
+	for i, c := range choices {
+		fmt.Fprintf(tty.Output(), "  [%d] %s\n", i+1, c)
+	}
+	fmt.Fprint(tty.Output(), message)
+
+	text, err := tty.ReadString()
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || i < 1 || i > len(choices) {
+		return 0, fmt.Errorf("invalid choice %q", strings.TrimSpace(text))
+	}
+
+	return i - 1, nil
+}
+
 func TerminalMfaPrompt(mfaSerial string) (string, error) {
 	return TerminalPrompt(mfaPromptMessage(mfaSerial))
 }
 
+func TerminalPassphrasePrompt(name string) (string, error) {
+	return TerminalSecretPrompt(passphrasePromptMessage(name))
+}
+
 func init() {
 	Methods["terminal"] = TerminalMfaPrompt
+	PassphraseMethods["terminal"] = TerminalPassphrasePrompt
 }
@@ -16,8 +16,20 @@ func ZenityMfaPrompt(mfaSerial string) (string, error) {
 	return strings.TrimSpace(string(out)), nil
 }
 
+func ZenityPassphrasePrompt(name string) (string, error) {
+	cmd := exec.Command("zenity", "--entry", "--hide-text", "--title", "aws-vault", "--text", passphrasePromptMessage(name))
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
 func init() {
 	if _, err := exec.LookPath("zenity"); err == nil {
 		Methods["zenity"] = ZenityMfaPrompt
+		PassphraseMethods["zenity"] = ZenityPassphrasePrompt
 	}
 }
@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errEc2NamespaceUnsupported = errors.New("running the EC2 metadata server in a network namespace is only supported on Linux")
+
+// Ec2Namespace starts a process in an unprivileged user and network namespace, which is only supported on Linux
+type Ec2Namespace struct{}
+
+func NewEc2Namespace(cmd *exec.Cmd) (*Ec2Namespace, error) {
+	return nil, errEc2NamespaceUnsupported
+}
+
+func (n *Ec2Namespace) Start() error {
+	return errEc2NamespaceUnsupported
+}
+
+func (n *Ec2Namespace) Close() {}
+
+func SetupEc2Namespace(socketPath string) error {
+	return errEc2NamespaceUnsupported
+}
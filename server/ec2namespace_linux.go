@@ -0,0 +1,191 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ec2NamespaceReadyFd is the file descriptor the namespace helper reads until the namespace network is ready
+const ec2NamespaceReadyFd = 3
+
+// Ec2Namespace starts a process in an unprivileged user and network namespace. The process is
+// expected to call SetupEc2Namespace, which serves the EC2 metadata endpoint on the namespace's
+// private loopback, so only that process tree can reach the credentials
+type Ec2Namespace struct {
+	cmd        *exec.Cmd
+	readyRead  *os.File
+	readyWrite *os.File
+	slirp      *exec.Cmd
+	slirpExit  *os.File
+}
+
+// NewEc2Namespace configures cmd to start in a new user and network namespace
+func NewEc2Namespace(cmd *exec.Cmd) (*Ec2Namespace, error) {
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.ExtraFiles = []*os.File{readyRead}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1},
+		},
+		// Capabilities only apply within the new namespaces, and are needed to configure the loopback
+		// and listen on port 80
+		AmbientCaps: []uintptr{unix.CAP_NET_ADMIN, unix.CAP_NET_BIND_SERVICE},
+	}
+
+	return &Ec2Namespace{
+		cmd:        cmd,
+		readyRead:  readyRead,
+		readyWrite: readyWrite,
+	}, nil
+}
+
+// Start starts the process and, if slirp4netns is installed, connects its namespace to the network
+func (n *Ec2Namespace) Start() error {
+	if err := n.cmd.Start(); err != nil {
+		return fmt.Errorf("Failed to start process in a user namespace, check that unprivileged user namespaces are enabled: %w", err)
+	}
+	n.readyRead.Close()
+
+	if err := n.connectNetwork(); err != nil {
+		log.Printf("Warning: %s", err.Error())
+		fmt.Fprintln(os.Stderr, "Warning: the namespace has no network access apart from the EC2 metadata endpoint. Install slirp4netns to connect it to the network")
+	}
+
+	// Let the process continue
+	return n.readyWrite.Close()
+}
+
+func (n *Ec2Namespace) connectNetwork() error {
+	slirpPath, err := exec.LookPath("slirp4netns")
+	if err != nil {
+		return err
+	}
+
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyRead.Close()
+
+	exitRead, exitWrite, err := os.Pipe()
+	if err != nil {
+		readyWrite.Close()
+		return err
+	}
+
+	n.slirp = exec.Command(slirpPath,
+		"--configure",
+		"--mtu=65520",
+		"--disable-host-loopback",
+		"--ready-fd=3",
+		"--exit-fd=4",
+		fmt.Sprintf("%d", n.cmd.Process.Pid),
+		"tap0",
+	)
+	n.slirp.ExtraFiles = []*os.File{readyWrite, exitRead}
+	n.slirp.Stderr = os.Stderr
+
+	log.Printf("Connecting the namespace to the network with %s", slirpPath)
+	err = n.slirp.Start()
+	readyWrite.Close()
+	exitRead.Close()
+	if err != nil {
+		exitWrite.Close()
+		n.slirp = nil
+		return err
+	}
+	n.slirpExit = exitWrite
+
+	// slirp4netns writes to the ready fd once the network is configured
+	b := make([]byte, 1)
+	if _, err := readyRead.Read(b); err != nil {
+		return fmt.Errorf("slirp4netns failed to configure the network: %w", err)
+	}
+
+	return nil
+}
+
+// Close disconnects the namespace from the network
+func (n *Ec2Namespace) Close() {
+	if n.slirp != nil {
+		n.slirpExit.Close()
+		_ = n.slirp.Wait()
+	}
+}
+
+// SetupEc2Namespace runs inside the namespace started by Ec2Namespace. It adds the EC2 metadata IP to the
+// private loopback, forwards connections to it to the credential server listening on socketPath, and waits
+// for the namespace's network to be ready. It then drops the capabilities used to configure the network
+// from the calling thread, so processes started from the calling goroutine don't inherit them
+func SetupEc2Namespace(socketPath string) error {
+	if output, err := exec.Command("ip", "link", "set", "lo", "up").CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", strings.TrimSpace(string(output)), err.Error())
+	}
+	if output, err := exec.Command("ip", "addr", "add", ec2MetadataEndpointIP+"/32", "dev", "lo").CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", strings.TrimSpace(string(output)), err.Error())
+	}
+
+	l, err := net.Listen("tcp", ec2MetadataEndpointAddr)
+	if err != nil {
+		return err
+	}
+	go forwardToSocket(l, socketPath)
+
+	ready := os.NewFile(ec2NamespaceReadyFd, "ready")
+	if ready == nil {
+		return errors.New("not started by aws-vault")
+	}
+	_, _ = io.Copy(io.Discard, ready)
+	ready.Close()
+
+	runtime.LockOSThread()
+	return unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0)
+}
+
+func forwardToSocket(l net.Listener, socketPath string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("EC2 metadata endpoint: %s", err.Error())
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			upstream, err := net.Dial("unix", socketPath)
+			if err != nil {
+				log.Printf("EC2 metadata endpoint: %s", err.Error())
+				return
+			}
+			defer upstream.Close()
+
+			go func() {
+				_, _ = io.Copy(upstream, conn)
+				_ = upstream.(*net.UnixConn).CloseWrite()
+			}()
+			_, _ = io.Copy(conn, upstream)
+		}()
+	}
+}
@@ -39,7 +39,14 @@ func StartProxy() error {
 		w.WriteHeader(http.StatusOK)
 		go Shutdown()
 	})
-	handler.Handle("/", httputil.NewSingleHostReverseProxy(localServerURL))
+	proxy := httputil.NewSingleHostReverseProxy(localServerURL)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		// Lets the credential server identify the process that connected to the proxy
+		r.Header.Set(ec2ProxyClientHeader, r.RemoteAddr)
+	}
+	handler.Handle("/", proxy)
 
 	log.Printf("EC2 Instance Metadata endpoint proxy server running on %s", l.Addr())
 	return http.Serve(l, handler)
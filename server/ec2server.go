@@ -7,31 +7,84 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/99designs/aws-vault/v7/iso8601"
 	"github.com/aws/aws-sdk-go-v2/aws"
 )
 
-const ec2CredentialsServerAddr = "127.0.0.1:9099"
+const (
+	ec2CredentialsServerAddr = "127.0.0.1:9099"
 
-// StartEc2CredentialsServer starts a EC2 Instance Metadata server and endpoint proxy
-func StartEc2CredentialsServer(ctx context.Context, credsProvider aws.CredentialsProvider, region string) error {
-	credsCache := aws.NewCredentialsCache(credsProvider)
+	ec2MetadataTokenPath      = "/latest/api/token"
+	ec2MetadataTokenHeader    = "X-aws-ec2-metadata-token"
+	ec2MetadataTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+
+	// ec2MetadataTokenMaxTTL is the longest IMDSv2 session token TTL an instance allows
+	ec2MetadataTokenMaxTTL = 21600
+)
+
+// StartEc2CredentialsServer starts a EC2 Instance Metadata server and endpoint proxy.
+// Providers that are already a credentials cache are used as-is. If requireToken is set,
+// IMDSv1 requests without a session token are rejected. If allowlist is set, only processes in it
+// receive credentials
+func StartEc2CredentialsServer(ctx context.Context, credsProvider aws.CredentialsProvider, region string, requireToken bool, allowlist *ProcessAllowlist) error {
+	credsCache := prefetchEc2Credentials(ctx, credsProvider)
+
+	go startEc2CredentialsServer(credsCache, region, requireToken, allowlist)
+
+	return nil
+}
+
+// StartEc2CredentialsServerOnSocket starts a EC2 Instance Metadata server listening on a unix socket,
+// for the endpoint in a network namespace started by Ec2Namespace
+func StartEc2CredentialsServerOnSocket(ctx context.Context, credsProvider aws.CredentialsProvider, region string, requireToken bool, socketPath string) error {
+	credsCache := prefetchEc2Credentials(ctx, credsProvider)
+
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Starting EC2 Instance Metadata server on %s", socketPath)
+	go func() {
+		// Connections to the socket don't have a remote IP, only the host is checked
+		log.Fatalln(http.Serve(l, withLogging(withHostCheck(ec2CredentialsHandler(credsCache, region, requireToken)))))
+	}()
+
+	return nil
+}
+
+func prefetchEc2Credentials(ctx context.Context, credsProvider aws.CredentialsProvider) *aws.CredentialsCache {
+	credsCache, ok := credsProvider.(*aws.CredentialsCache)
+	if !ok {
+		credsCache = aws.NewCredentialsCache(credsProvider)
+	}
 
 	// pre-fetch credentials so that we can respond quickly to the first request
 	// SDKs seem to very aggressively timeout
 	_, _ = credsCache.Retrieve(ctx)
 
-	go startEc2CredentialsServer(credsCache, region)
-
-	return nil
+	return credsCache
 }
 
-func startEc2CredentialsServer(credsProvider aws.CredentialsProvider, region string) {
+func startEc2CredentialsServer(credsProvider aws.CredentialsProvider, region string, requireToken bool, allowlist *ProcessAllowlist) {
 	log.Printf("Starting EC2 Instance Metadata server on %s", ec2CredentialsServerAddr)
+
+	handler := withProcessCheck(allowlist, ec2CredentialsHandler(credsProvider, region, requireToken))
+	log.Fatalln(http.ListenAndServe(ec2CredentialsServerAddr, withLogging(withSecurityChecks(handler))))
+}
+
+func ec2CredentialsHandler(credsProvider aws.CredentialsProvider, region string, requireToken bool) http.Handler {
 	router := http.NewServeMux()
 
+	tokens := &ec2MetadataTokens{tokens: map[string]time.Time{}}
+
+	// IMDSv2 session tokens
+	router.HandleFunc(ec2MetadataTokenPath, tokens.tokenHandler)
+
 	router.HandleFunc("/latest/meta-data/iam/security-credentials/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "local-credentials")
 	})
@@ -53,11 +106,86 @@ func startEc2CredentialsServer(credsProvider aws.CredentialsProvider, region str
 
 	router.HandleFunc("/latest/meta-data/iam/security-credentials/local-credentials", credsHandler(credsProvider))
 
-	log.Fatalln(http.ListenAndServe(ec2CredentialsServerAddr, withLogging(withSecurityChecks(router))))
+	return withMetadataTokenCheck(tokens, requireToken, router)
+}
+
+// ec2MetadataTokens issues and validates IMDSv2 session tokens
+type ec2MetadataTokens struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+func (t *ec2MetadataTokens) issue(ttl time.Duration) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for token, expires := range t.tokens {
+		if now.After(expires) {
+			delete(t.tokens, token)
+		}
+	}
+
+	token := generateRandomString()
+	t.tokens[token] = now.Add(ttl)
+
+	return token
+}
+
+func (t *ec2MetadataTokens) isValid(token string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expires, ok := t.tokens[token]
+	return ok && time.Now().Before(expires)
+}
+
+func (t *ec2MetadataTokens) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.Header().Set("Allow", http.MethodPut)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ttl, err := strconv.Atoi(r.Header.Get(ec2MetadataTokenTTLHeader))
+	if err != nil || ttl < 1 || ttl > ec2MetadataTokenMaxTTL {
+		http.Error(w, fmt.Sprintf("%s must be between 1 and %d", ec2MetadataTokenTTLHeader, ec2MetadataTokenMaxTTL), http.StatusBadRequest)
+		return
+	}
+
+	token := t.issue(time.Duration(ttl) * time.Second)
+
+	w.Header().Set(ec2MetadataTokenTTLHeader, strconv.Itoa(ttl))
+	fmt.Fprint(w, token)
+}
+
+// withMetadataTokenCheck is middleware that validates IMDSv2 session tokens, and rejects IMDSv1
+// requests without a token if requireToken is set
+func withMetadataTokenCheck(tokens *ec2MetadataTokens, requireToken bool, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == ec2MetadataTokenPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := r.Header.Get(ec2MetadataTokenHeader)
+		if token == "" && requireToken {
+			http.Error(w, "Unauthorized: IMDSv1 is disabled, a session token is required", http.StatusUnauthorized)
+			return
+		}
+		if token != "" && !tokens.isValid(token) {
+			http.Error(w, "Unauthorized: invalid or expired session token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
 }
 
 // withSecurityChecks is middleware to protect the server from attack vectors
-func withSecurityChecks(next *http.ServeMux) http.HandlerFunc {
+func withSecurityChecks(next http.Handler) http.HandlerFunc {
+	hostChecked := withHostCheck(next)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Check the remote ip is from the loopback, otherwise clients on the same network segment could
 		// potentially route traffic via 169.254.169.254:80
@@ -72,6 +200,13 @@ func withSecurityChecks(next *http.ServeMux) http.HandlerFunc {
 			return
 		}
 
+		hostChecked.ServeHTTP(w, r)
+	}
+}
+
+// withHostCheck is middleware that only allows requests to the EC2 metadata endpoint
+func withHostCheck(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		// Check that the request is to 169.254.169.254
 		// Without this it's possible for an attacker to mount a DNS rebinding attack
 		// See https://github.com/99designs/aws-vault/issues/578
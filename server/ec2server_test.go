@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const ec2CredentialsPath = "/latest/meta-data/iam/security-credentials/local-credentials"
+
+func newEc2TestServer(t *testing.T, requireToken bool) *httptest.Server {
+	t.Helper()
+	creds := aws.Credentials{AccessKeyID: "ASIATESTEXAMPLE1", SecretAccessKey: "secret", SessionToken: "token", CanExpire: true, Expires: time.Now().Add(time.Hour)}
+	ts := httptest.NewServer(ec2CredentialsHandler(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return creds, nil
+	}), "us-east-1", requireToken))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func doEc2Request(t *testing.T, method, url string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestEc2MetadataTokenFlow(t *testing.T) {
+	ts := newEc2TestServer(t, true)
+
+	resp, token := doEc2Request(t, http.MethodPut, ts.URL+ec2MetadataTokenPath, http.Header{ec2MetadataTokenTTLHeader: {"60"}})
+	if resp.StatusCode != http.StatusOK || token == "" {
+		t.Fatalf("Expected a token, got %d %q", resp.StatusCode, token)
+	}
+	if ttl := resp.Header.Get(ec2MetadataTokenTTLHeader); ttl != "60" {
+		t.Fatalf("Expected the token TTL in the response, got %q", ttl)
+	}
+
+	resp, body := doEc2Request(t, http.MethodGet, ts.URL+ec2CredentialsPath, http.Header{ec2MetadataTokenHeader: {token}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected credentials with a token, got %d %s", resp.StatusCode, body)
+	}
+	var creds struct{ AccessKeyId string }
+	if err := json.Unmarshal([]byte(body), &creds); err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyId != "ASIATESTEXAMPLE1" {
+		t.Fatalf("Unexpected credentials %s", body)
+	}
+}
+
+func TestEc2MetadataTokenTTL(t *testing.T) {
+	ts := newEc2TestServer(t, true)
+
+	tests := []struct {
+		ttl    string
+		status int
+	}{
+		{"1", http.StatusOK},
+		{"21600", http.StatusOK},
+		{"0", http.StatusBadRequest},
+		{"-1", http.StatusBadRequest},
+		{"21601", http.StatusBadRequest},
+		{"ten", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.ttl != "" {
+			header.Set(ec2MetadataTokenTTLHeader, tt.ttl)
+		}
+		if resp, body := doEc2Request(t, http.MethodPut, ts.URL+ec2MetadataTokenPath, header); resp.StatusCode != tt.status {
+			t.Errorf("TTL %q: expected status %d, got %d %s", tt.ttl, tt.status, resp.StatusCode, body)
+		}
+	}
+
+	resp, _ := doEc2Request(t, http.MethodGet, ts.URL+ec2MetadataTokenPath, http.Header{ec2MetadataTokenTTLHeader: {"60"}})
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPut {
+		t.Fatalf("Expected tokens to require PUT, got %d", resp.StatusCode)
+	}
+}
+
+func TestWithMetadataTokenCheck(t *testing.T) {
+	tokens := &ec2MetadataTokens{tokens: map[string]time.Time{
+		"valid":   time.Now().Add(time.Minute),
+		"expired": time.Now().Add(-time.Second),
+	}}
+
+	tests := []struct {
+		name         string
+		requireToken bool
+		token        string
+		status       int
+	}{
+		{"valid token", true, "valid", http.StatusOK},
+		{"expired token", true, "expired", http.StatusUnauthorized},
+		{"unknown token", true, "unknown", http.StatusUnauthorized},
+		{"missing header", true, "", http.StatusUnauthorized},
+		{"IMDSv1 allowed", false, "", http.StatusOK},
+		{"expired token with IMDSv1 allowed", false, "expired", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		handler := withMetadataTokenCheck(tokens, tt.requireToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		r := httptest.NewRequest(http.MethodGet, ec2CredentialsPath, nil)
+		if tt.token != "" {
+			r.Header.Set(ec2MetadataTokenHeader, tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d %s", tt.name, tt.status, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+	}
+}
+
+func TestEc2MetadataTokensExpire(t *testing.T) {
+	tokens := &ec2MetadataTokens{tokens: map[string]time.Time{"expired": time.Now().Add(-time.Second)}}
+
+	token := tokens.issue(time.Minute)
+	if !tokens.isValid(token) {
+		t.Fatal("Expected the issued token to be valid")
+	}
+	if _, ok := tokens.tokens["expired"]; ok {
+		t.Fatal("Expected expired tokens to be removed when issuing a token")
+	}
+
+	if token = tokens.issue(-time.Second); tokens.isValid(token) {
+		t.Fatal("Expected a token past its TTL to be invalid")
+	}
+}
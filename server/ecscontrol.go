@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/99designs/aws-vault/v7/iso8601"
+	"github.com/99designs/aws-vault/v7/vault"
+)
+
+// EcsServerStatus describes the credentials an ECS server is serving
+type EcsServerStatus struct {
+	Profile     string `json:"profile"`
+	RoleARN     string `json:"role_arn,omitempty"`
+	AccessKeyID string `json:"access_key_id"`
+	Expiration  string `json:"expiration,omitempty"`
+}
+
+// SetSessionKeyring allows Refresh to remove the profile's cached sessions from the keyring
+func (e *EcsServer) SetSessionKeyring(sessions *vault.SessionKeyring) {
+	e.sessions = sessions
+}
+
+// Status returns the profile the server is serving and its current credentials
+func (e *EcsServer) Status(ctx context.Context) (*EcsServerStatus, error) {
+	e.mu.RLock()
+	config := e.config
+	e.mu.RUnlock()
+
+	creds, err := e.baseCredsProvider.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &EcsServerStatus{
+		Profile:     config.ProfileName,
+		RoleARN:     config.RoleARN,
+		AccessKeyID: vault.FormatKeyForDisplay(creds.AccessKeyID),
+	}
+	if creds.CanExpire {
+		status.Expiration = iso8601.Format(creds.Expires)
+	}
+	return status, nil
+}
+
+// Refresh discards all cached credentials, including the profile's sessions in the keyring, and gets a new session
+func (e *EcsServer) Refresh(ctx context.Context) error {
+	e.mu.RLock()
+	config := e.config
+	e.mu.RUnlock()
+
+	if e.sessions != nil {
+		for c := config; c != nil; c = c.SourceProfile {
+			if _, err := e.sessions.RemoveForProfile(c.ProfileName); err != nil {
+				return err
+			}
+		}
+	}
+
+	e.profileMu.Lock()
+	e.baseCredsProvider.Invalidate()
+	e.roleCache.clear()
+	clearCache(&e.profileCache)
+	e.profileMu.Unlock()
+
+	if _, err := e.baseCredsProvider.Retrieve(ctx); err != nil {
+		return fmt.Errorf("Retrieving creds: %w", err)
+	}
+	return nil
+}
+
+func (e *EcsServer) ControlStatusRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorMessage(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	e.writeStatus(w, r)
+}
+
+func (e *EcsServer) writeStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := e.Status(r.Context())
+	if err != nil {
+		writeErrorMessage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = json.NewEncoder(w).Encode(status); err != nil {
+		writeErrorMessage(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (e *EcsServer) ControlRefreshRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorMessage(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Println("Refreshing credentials")
+	if err := e.Refresh(r.Context()); err != nil {
+		writeErrorMessage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeStatus(w, r)
+}
+
+func (e *EcsServer) ControlShutdownRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorMessage(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Println("Shutting down on request")
+	w.WriteHeader(http.StatusNoContent)
+
+	// Shutdown waits for active requests, including this one, so it can't be called from the handler
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := e.Shutdown(ctx); err != nil {
+			log.Printf("ecs server: %s", err.Error())
+		}
+	}()
+}
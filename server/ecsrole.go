@@ -0,0 +1,163 @@
+package server
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// ecsRoleCacheSize is the number of role credential providers the ECS server keeps
+const ecsRoleCacheSize = 100
+
+// roleRequest is a request for role credentials on the /role-arn/ route. Parameters are taken from
+// the query string, or from X-Aws-Vault-* headers for clients that can't change the URL
+type roleRequest struct {
+	RoleARN         string            `json:"role_arn"`
+	ExternalID      string            `json:"external_id,omitempty"`
+	RoleSessionName string            `json:"session_name,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	SourceIdentity  string            `json:"source_identity,omitempty"`
+	PolicyARNs      []string          `json:"policy_arns,omitempty"`
+	Policy          string            `json:"policy,omitempty"`
+}
+
+func parseRoleRequest(r *http.Request) (*roleRequest, error) {
+	query := r.URL.Query()
+	param := func(name, header string) string {
+		if v := query.Get(name); v != "" {
+			return v
+		}
+		return r.Header.Get(header)
+	}
+	params := func(name, header string) []string {
+		if v := query[name]; len(v) > 0 {
+			return v
+		}
+		if v := r.Header.Get(header); v != "" {
+			return strings.Split(v, ",")
+		}
+		return nil
+	}
+
+	req := &roleRequest{
+		RoleARN:         strings.TrimPrefix(r.URL.Path, "/role-arn/"),
+		ExternalID:      param("external_id", "X-Aws-Vault-External-Id"),
+		RoleSessionName: param("session_name", "X-Aws-Vault-Session-Name"),
+		SourceIdentity:  param("source_identity", "X-Aws-Vault-Source-Identity"),
+		Policy:          param("policy", "X-Aws-Vault-Policy"),
+	}
+	for _, p := range params("policy_arn", "X-Aws-Vault-Policy-Arns") {
+		if p = strings.TrimSpace(p); p != "" {
+			req.PolicyARNs = append(req.PolicyARNs, p)
+		}
+	}
+	for _, tag := range params("tag", "X-Aws-Vault-Session-Tags") {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tag '%s', tags must be key=value", tag)
+		}
+		if req.Tags == nil {
+			req.Tags = map[string]string{}
+		}
+		req.Tags[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return req, nil
+}
+
+// cacheKey identifies the request's credentials. Map keys are marshalled in order, so equal requests have equal keys
+func (req *roleRequest) cacheKey() string {
+	b, _ := json.Marshal(req)
+	return string(b)
+}
+
+func (req *roleRequest) hasSessionPolicies() bool {
+	return len(req.PolicyARNs) > 0 || req.Policy != ""
+}
+
+// errRoleARNRouteDisabled is returned for every request to the /role-arn/ route when ecs_allowed_role_arns isn't set
+var errRoleARNRouteDisabled = errors.New("the /role-arn/ route is disabled, set ecs_allowed_role_arns to the roles it may assume")
+
+// validateRoleARN checks the role ARN is an IAM role that matches one of the patterns. Without
+// patterns no roles are allowed, so the route has to be enabled explicitly
+func validateRoleARN(roleARN string, patterns []string) error {
+	a, err := arn.Parse(roleARN)
+	if err != nil || a.Service != "iam" || !strings.HasPrefix(a.Resource, "role/") {
+		return fmt.Errorf("'%s' is not an IAM role ARN", roleARN)
+	}
+
+	if len(patterns) == 0 {
+		return errRoleARNRouteDisabled
+	}
+	for _, pattern := range patterns {
+		if matchesARNPattern(pattern, roleARN) {
+			return nil
+		}
+	}
+	return fmt.Errorf("role %s isn't allowed by ecs_allowed_role_arns", roleARN)
+}
+
+// matchesARNPattern matches an ARN against a pattern where * matches any characters, including slashes in role paths
+func matchesARNPattern(pattern, s string) bool {
+	re := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	ok, _ := regexp.MatchString(re, s)
+	return ok
+}
+
+// roleProviderCache keeps the most recently used role credential providers
+type roleProviderCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type roleProviderCacheEntry struct {
+	key      string
+	provider *aws.CredentialsCache
+}
+
+func newRoleProviderCache(size int) *roleProviderCache {
+	return &roleProviderCache{
+		size:  size,
+		order: list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+// getOrAdd returns the cached provider for key, or caches the one returned by create
+func (c *roleProviderCache) getOrAdd(key string, create func() *aws.CredentialsCache) *aws.CredentialsCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*roleProviderCacheEntry).provider
+	}
+
+	entry := &roleProviderCacheEntry{key: key, provider: create()}
+	c.items[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*roleProviderCacheEntry).key)
+	}
+
+	return entry.provider
+}
+
+func (c *roleProviderCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.items = map[string]*list.Element{}
+}
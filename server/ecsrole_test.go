@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/99designs/aws-vault/v7/vault"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestValidateRoleARN(t *testing.T) {
+	patterns := []string{
+		"arn:aws:iam::111111111111:role/ci-*",
+		"arn:aws:iam::222222222222:role/deploy",
+		"arn:aws-cn:iam::333333333333:role/*",
+	}
+
+	tests := []struct {
+		roleARN string
+		ok      bool
+	}{
+		{"arn:aws:iam::111111111111:role/ci-deploy", true},
+		{"arn:aws:iam::111111111111:role/ci-", true},
+		{"arn:aws:iam::111111111111:role/ci-team/with/path", true},
+		{"arn:aws:iam::111111111111:role/deploy", false},
+		{"arn:aws:iam::222222222222:role/deploy", true},
+		{"arn:aws:iam::222222222222:role/deploy-admin", false},
+		{"arn:aws:iam::222222222222:role/path/deploy", false},
+		{"arn:aws-cn:iam::333333333333:role/any/role", true},
+		{"arn:aws:iam::333333333333:role/any", false},
+		{"arn:aws-us-gov:iam::111111111111:role/ci-deploy", false},
+		{"arn:aws:iam::111111111111:user/ci-deploy", false},
+		{"arn:aws:s3:::ci-bucket", false},
+		{"ci-deploy", false},
+	}
+	for _, tt := range tests {
+		if err := validateRoleARN(tt.roleARN, patterns); (err == nil) != tt.ok {
+			t.Errorf("validateRoleARN(%q) = %v, expected allowed: %v", tt.roleARN, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateRoleARNDisabled(t *testing.T) {
+	err := validateRoleARN("arn:aws:iam::111111111111:role/ci-deploy", nil)
+	if !errors.Is(err, errRoleARNRouteDisabled) {
+		t.Fatalf("Expected the route to be disabled, got %v", err)
+	}
+
+	if err = validateRoleARN("arn:aws:iam::111111111111:role/ci-deploy", []string{"*"}); err != nil {
+		t.Fatalf("Expected * to allow any role, got %v", err)
+	}
+}
+
+func TestMatchesARNPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		ok      bool
+	}{
+		{"*", "arn:aws:iam::111111111111:role/any", true},
+		{"arn:aws:iam::*:role/deploy", "arn:aws:iam::111111111111:role/deploy", true},
+		{"arn:aws:iam::*:role/deploy", "arn:aws:iam::111111111111:role/deploy2", false},
+		{"arn:aws:iam::111111111111:role/a.b", "arn:aws:iam::111111111111:role/aXb", false},
+		{"arn:aws:iam::111111111111:role/a+b", "arn:aws:iam::111111111111:role/a+b", true},
+		{"arn:aws:iam::111111111111:role/*-prod", "arn:aws:iam::111111111111:role/team/app-prod", true},
+	}
+	for _, tt := range tests {
+		if ok := matchesARNPattern(tt.pattern, tt.s); ok != tt.ok {
+			t.Errorf("matchesARNPattern(%q, %q) = %v, expected %v", tt.pattern, tt.s, ok, tt.ok)
+		}
+	}
+}
+
+func TestRoleProviderCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newRoleProviderCache(2)
+	created := map[string]int{}
+	get := func(key string) *aws.CredentialsCache {
+		return cache.getOrAdd(key, func() *aws.CredentialsCache {
+			created[key]++
+			return aws.NewCredentialsCache(aws.AnonymousCredentials{})
+		})
+	}
+
+	a := get("a")
+	get("b")
+	if get("a") != a {
+		t.Fatal("Expected the cached provider for a")
+	}
+	// b is now the least recently used
+	get("c")
+	get("a")
+	get("b")
+
+	expected := map[string]int{"a": 1, "b": 2, "c": 1}
+	for key, n := range expected {
+		if created[key] != n {
+			t.Errorf("Expected the provider for %s to be created %d times, got %d", key, n, created[key])
+		}
+	}
+	if cache.order.Len() != 2 || len(cache.items) != 2 {
+		t.Fatalf("Expected the cache to hold 2 providers, got %d", cache.order.Len())
+	}
+
+	cache.clear()
+	if cache.order.Len() != 0 || len(cache.items) != 0 {
+		t.Fatal("Expected the cache to be empty after clearing it")
+	}
+}
+
+func TestAssumeRoleArnRouteDisabled(t *testing.T) {
+	e := &EcsServer{config: &vault.ProfileConfig{ProfileName: "test"}, roleCache: newRoleProviderCache(ecsRoleCacheSize)}
+
+	w := httptest.NewRecorder()
+	e.AssumeRoleArnRoute(w, httptest.NewRequest(http.MethodGet, "/role-arn/arn:aws:iam::111111111111:role/ci-deploy", nil))
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "ecs_allowed_role_arns") {
+		t.Fatalf("Expected the response to explain how to enable the route, got %s", w.Body.String())
+	}
+}
@@ -3,14 +3,21 @@ package server
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/99designs/aws-vault/v7/iso8601"
 	"github.com/99designs/aws-vault/v7/vault"
@@ -26,16 +33,6 @@ func writeErrorMessage(w http.ResponseWriter, msg string, statusCode int) {
 	}
 }
 
-func withAuthorizationCheck(authToken string, next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Authorization") != authToken {
-			writeErrorMessage(w, "invalid Authorization token", http.StatusForbidden)
-			return
-		}
-		next.ServeHTTP(w, r)
-	}
-}
-
 func writeCredsToResponse(creds aws.Credentials, w http.ResponseWriter) {
 	err := json.NewEncoder(w).Encode(map[string]string{
 		"AccessKeyId":     creds.AccessKeyID,
@@ -57,52 +54,366 @@ func generateRandomString() string {
 	return base64.RawURLEncoding.EncodeToString(b)
 }
 
+// reloadableProvider allows the provider behind a credentials cache to be replaced
+type reloadableProvider struct {
+	mu       sync.RWMutex
+	provider aws.CredentialsProvider
+}
+
+func (p *reloadableProvider) set(provider aws.CredentialsProvider) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.provider = provider
+}
+
+func (p *reloadableProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	p.mu.RLock()
+	provider := p.provider
+	p.mu.RUnlock()
+	return provider.Retrieve(ctx)
+}
+
+// EcsListenOptions controls where and how the ECS server listens
+type EcsListenOptions struct {
+	// IP to bind to. Defaults to 127.0.0.1
+	IP net.IP
+
+	// Port to bind to. Defaults to a random port
+	Port int
+
+	// AllowedNetworks are the networks that clients may connect from, in addition to the loopback network
+	AllowedNetworks []*net.IPNet
+
+	// TLSDir enables HTTPS, with a certificate signed by a local CA that is kept in this directory
+	TLSDir string
+}
+
+// ParseBindAddress parses an IP address, or returns the first IPv4 address of the named network interface
+func ParseBindAddress(s string) (net.IP, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip, nil
+	}
+
+	iface, err := net.InterfaceByName(s)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not an IP address or network interface", s)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("network interface %s has no IPv4 address", s)
+}
+
 type EcsServer struct {
 	listener          net.Listener
-	authToken         string
+	tls               bool
+	caBundlePath      string
+	allowedNetworks   []*net.IPNet
+	tokens            ecsTokens
+	auditLog          *ecsAuditLog
+	stopRotation      chan struct{}
+	shutdownOnce      sync.Once
+	sessions          *vault.SessionKeyring
 	server            http.Server
-	cache             sync.Map
-	baseCredsProvider aws.CredentialsProvider
-	config            *vault.ProfileConfig
+	roleCache         *roleProviderCache
+	baseProvider      reloadableProvider
+	baseCredsProvider *aws.CredentialsCache
+
+	mu     sync.RWMutex
+	config *vault.ProfileConfig
+
+	profileMu       sync.Mutex
+	profileCache    sync.Map
+	profileConfig   vault.ProfileConfig
+	configFile      *vault.ConfigFile
+	keyring         *vault.CredentialKeyring
+	allowedProfiles []string
+
+	processAllowlist *ProcessAllowlist
+
+	// roleRouteDisabledWarning warns once that requests are refused because the /role-arn/ route is disabled
+	roleRouteDisabledWarning sync.Once
 }
 
-func NewEcsServer(ctx context.Context, baseCredsProvider aws.CredentialsProvider, config *vault.ProfileConfig, authToken string, port int, lazyLoadBaseCreds bool) (*EcsServer, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
-	if err != nil {
-		return nil, err
+func NewEcsServer(ctx context.Context, baseCredsProvider aws.CredentialsProvider, config *vault.ProfileConfig, authToken string, listen EcsListenOptions, lazyLoadBaseCreds bool) (*EcsServer, error) {
+	if listen.IP == nil {
+		listen.IP = net.IPv4(127, 0, 0, 1)
 	}
 	if authToken == "" {
 		authToken = generateRandomString()
 	}
 
-	credsCache := aws.NewCredentialsCache(baseCredsProvider)
-	if !lazyLoadBaseCreds {
-		_, err := credsCache.Retrieve(ctx)
+	e := &EcsServer{
+		allowedNetworks: listen.AllowedNetworks,
+		roleCache:       newRoleProviderCache(ecsRoleCacheSize),
+		stopRotation:    make(chan struct{}),
+		config:          config,
+	}
+
+	var tlsConfig *tls.Config
+	if listen.TLSDir != "" {
+		ca, caKey, err := loadOrCreateEcsCA(listen.TLSDir)
 		if err != nil {
-			return nil, fmt.Errorf("Retrieving creds: %w", err)
+			return nil, fmt.Errorf("Loading CA: %w", err)
 		}
+		ips, dnsNames := certificateHosts(listen.IP)
+		cert, err := newEcsCertificate(ca, caKey, ips, dnsNames)
+		if err != nil {
+			return nil, fmt.Errorf("Creating certificate: %w", err)
+		}
+		// The bundle replaces the subprocess's AWS_CA_BUNDLE, so it keeps trusting what that and the profile's ca_bundle trust
+		if e.caBundlePath, err = writeEcsCABundle(listen.TLSDir, ca, config.CABundle, os.Getenv("AWS_CA_BUNDLE")); err != nil {
+			return nil, fmt.Errorf("Writing CA bundle: %w", err)
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		e.tls = true
 	}
 
-	e := &EcsServer{
-		listener:          listener,
-		authToken:         authToken,
-		baseCredsProvider: credsCache,
-		config:            config,
+	listener, err := net.Listen("tcp", net.JoinHostPort(listen.IP.String(), strconv.Itoa(listen.Port)))
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	e.listener = listener
+
+	if err = e.tokens.add(DefaultEcsClientName, authToken); err != nil {
+		return nil, err
+	}
+	e.baseProvider.set(baseCredsProvider)
+	e.baseCredsProvider = aws.NewCredentialsCache(&e.baseProvider)
+
+	if !lazyLoadBaseCreds {
+		_, err := e.baseCredsProvider.Retrieve(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Retrieving creds: %w", err)
+		}
 	}
 
 	router := http.NewServeMux()
 	router.HandleFunc("/", e.DefaultRoute)
 	router.HandleFunc("/role-arn/", e.AssumeRoleArnRoute)
-	e.server.Handler = withLogging(withAuthorizationCheck(e.authToken, router.ServeHTTP))
+	router.HandleFunc("/profile/", e.ProfileRoute)
+	router.HandleFunc("/control/status", e.ControlStatusRoute)
+	router.HandleFunc("/control/refresh", e.ControlRefreshRoute)
+	router.HandleFunc("/control/shutdown", e.ControlShutdownRoute)
+	e.server.Handler = withLogging(e.withNetworkCheck(e.withAuthorizationCheck(e.withProcessCheck(router))))
 
 	return e, nil
 }
 
 func (e *EcsServer) BaseURL() string {
-	return fmt.Sprintf("http://%s", e.listener.Addr().String())
+	scheme := "http"
+	if e.tls {
+		scheme = "https"
+	}
+
+	addr := e.listener.Addr().(*net.TCPAddr)
+	host := addr.IP.String()
+	if addr.IP.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(addr.Port)))
+}
+
+// Port returns the port the server listens on
+func (e *EcsServer) Port() int {
+	return e.listener.Addr().(*net.TCPAddr).Port
 }
+
+// CABundlePath returns the path of a CA bundle that trusts the server's certificate, or "" when not using TLS
+func (e *EcsServer) CABundlePath() string {
+	return e.caBundlePath
+}
+
+// withNetworkCheck is middleware that only allows requests from the loopback network or the allowed networks
+func (e *EcsServer) withNetworkCheck(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			writeErrorMessage(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !(ip.IsLoopback() || containsIP(e.allowedNetworks, ip)) {
+			writeErrorMessage(w, fmt.Sprintf("Access denied from %s", host), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthToken returns the default client's current authorization token
 func (e *EcsServer) AuthToken() string {
-	return e.authToken
+	return e.tokens.token(DefaultEcsClientName)
+}
+
+// SetTokenFile writes the default client's token to a private file, which is kept up to date when tokens rotate
+func (e *EcsServer) SetTokenFile(path string) error {
+	return e.tokens.setFile(DefaultEcsClientName, path)
+}
+
+// AddClient adds a named client with its own token, which is written to tokenFile if it is set
+func (e *EcsServer) AddClient(name, tokenFile string) error {
+	if err := e.tokens.add(name, generateRandomString()); err != nil {
+		return err
+	}
+	if tokenFile != "" {
+		return e.tokens.setFile(name, tokenFile)
+	}
+	return nil
+}
+
+// RotateTokens replaces every client's token each interval. Previous tokens are accepted for a short
+// grace window, so clients have time to re-read their token files
+func (e *EcsServer) RotateTokens(interval time.Duration) {
+	grace := ecsTokenGraceWindow
+	if interval < grace {
+		grace = interval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.stopRotation:
+				return
+			case <-ticker.C:
+				e.tokens.rotate(grace)
+			}
+		}
+	}()
+}
+
+// SetAuditLog writes an entry for each request, with the name of the client that made it, to w.
+// It must be called before Serve
+func (e *EcsServer) SetAuditLog(w io.Writer) {
+	e.auditLog = &ecsAuditLog{w: w}
+}
+
+func (e *EcsServer) withAuthorizationCheck(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, ok := e.tokens.authorize(r.Header.Get("Authorization"))
+		if !ok {
+			e.audit(r, "", http.StatusForbidden)
+			writeErrorMessage(w, "invalid Authorization token", http.StatusForbidden)
+			return
+		}
+		// Named clients can only get credentials, the server is controlled with the default token
+		if strings.HasPrefix(r.URL.Path, "/control/") && client != DefaultEcsClientName {
+			e.audit(r, client, http.StatusForbidden)
+			writeErrorMessage(w, fmt.Sprintf("Client %s can't use the control endpoints", client), http.StatusForbidden)
+			return
+		}
+
+		w2 := &loggingMiddlewareResponseWriter{w, http.StatusOK}
+		next.ServeHTTP(w2, r)
+		e.audit(r, client, w2.Code)
+	}
+}
+
+func (e *EcsServer) audit(r *http.Request, client string, status int) {
+	if e.auditLog != nil {
+		e.auditLog.write(r, client, status)
+	} else if client != "" {
+		log.Printf("ecs server: request from client %q", client)
+	}
+}
+
+// ServeProfiles allows credentials for the named profiles to be requested on /profile/<name>.
+// It must be called before Serve
+func (e *EcsServer) ServeProfiles(baseConfig vault.ProfileConfig, configFile *vault.ConfigFile, keyring *vault.CredentialKeyring, profileNames []string) {
+	// Session policies given on the command line only apply to the served profile,
+	// as GetProfileConfig does for source profiles
+	baseConfig.PolicyARNs = nil
+	baseConfig.Policy = ""
+	baseConfig.PolicyFile = ""
+	e.profileConfig = baseConfig
+	e.configFile = configFile
+	e.keyring = keyring
+	e.allowedProfiles = profileNames
+}
+
+// RestrictProcesses only allows processes in the allowlist to receive credentials.
+// It must be called before Serve
+func (e *EcsServer) RestrictProcesses(allowlist *ProcessAllowlist) {
+	e.processAllowlist = allowlist
+}
+
+func (e *EcsServer) withProcessCheck(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		withProcessCheck(e.processAllowlist, next).ServeHTTP(w, r)
+	}
+}
+
+// BaseCredentialsProvider returns the cached provider for the server's base credentials, which
+// follows the server across reloads
+func (e *EcsServer) BaseCredentialsProvider() *aws.CredentialsCache {
+	return e.baseCredsProvider
+}
+
+// Reload replaces the base credentials and config, discarding any cached credentials
+func (e *EcsServer) Reload(ctx context.Context, baseCredsProvider aws.CredentialsProvider, config *vault.ProfileConfig, configFile *vault.ConfigFile, lazyLoadBaseCreds bool) error {
+	e.profileMu.Lock()
+	e.mu.Lock()
+	e.baseProvider.set(baseCredsProvider)
+	e.config = config
+	e.configFile = configFile
+	e.mu.Unlock()
+
+	e.baseCredsProvider.Invalidate()
+	e.roleCache.clear()
+	clearCache(&e.profileCache)
+	e.profileMu.Unlock()
+
+	if !lazyLoadBaseCreds {
+		_, err := e.baseCredsProvider.Retrieve(ctx)
+		if err != nil {
+			return fmt.Errorf("Retrieving creds: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func clearCache(m *sync.Map) {
+	m.Range(func(k, _ interface{}) bool {
+		m.Delete(k)
+		return true
+	})
+}
+
+// Shutdown gracefully stops the server, waiting for active requests to finish
+func (e *EcsServer) Shutdown(ctx context.Context) error {
+	e.shutdownOnce.Do(func() { close(e.stopRotation) })
+	return e.server.Shutdown(ctx)
+}
+
+// ProfileURL returns the URL that serves credentials for the named profile
+func (e *EcsServer) ProfileURL(profileName string) string {
+	return e.BaseURL() + "/profile/" + url.PathEscape(profileName)
 }
 
 func (e *EcsServer) Serve() error {
@@ -118,29 +429,123 @@ func (e *EcsServer) DefaultRoute(w http.ResponseWriter, r *http.Request) {
 	writeCredsToResponse(creds, w)
 }
 
-func (e *EcsServer) getRoleProvider(roleArn string) aws.CredentialsProvider {
-	var roleProviderCache *aws.CredentialsCache
+func (e *EcsServer) getRoleProvider(req *roleRequest) aws.CredentialsProvider {
+	return e.roleCache.getOrAdd(req.cacheKey(), func() *aws.CredentialsCache {
+		e.mu.RLock()
+		config := e.config
+		e.mu.RUnlock()
 
-	v, ok := e.cache.Load(roleArn)
-	if ok {
-		roleProviderCache = v.(*aws.CredentialsCache)
-	} else {
-		cfg := vault.NewAwsConfigWithCredsProvider(e.baseCredsProvider, e.config.Region, e.config.STSRegionalEndpoints)
-		roleProvider := &vault.AssumeRoleProvider{
-			StsClient: sts.NewFromConfig(cfg),
-			RoleARN:   roleArn,
-			Duration:  e.config.AssumeRoleDuration,
+		policyARNs, policy := config.PolicyARNs, config.Policy
+		if req.hasSessionPolicies() {
+			policyARNs, policy = req.PolicyARNs, req.Policy
 		}
-		roleProviderCache = aws.NewCredentialsCache(roleProvider)
-		e.cache.Store(roleArn, roleProviderCache)
-	}
-	return roleProviderCache
+
+		cfg := vault.NewAwsConfigWithCredsProvider(e.baseCredsProvider, config.Region, config)
+		return aws.NewCredentialsCache(&vault.AssumeRoleProvider{
+			StsClient:       sts.NewFromConfig(cfg),
+			RoleARN:         req.RoleARN,
+			RoleSessionName: req.RoleSessionName,
+			ExternalID:      req.ExternalID,
+			Duration:        config.AssumeRoleDuration,
+			Tags:            req.Tags,
+			SourceIdentity:  req.SourceIdentity,
+			PolicyARNs:      policyARNs,
+			Policy:          policy,
+		})
+	})
 }
 
 func (e *EcsServer) AssumeRoleArnRoute(w http.ResponseWriter, r *http.Request) {
-	roleArn := strings.TrimPrefix(r.URL.Path, "/role-arn/")
-	roleProvider := e.getRoleProvider(roleArn)
-	creds, err := roleProvider.Retrieve(r.Context())
+	req, err := parseRoleRequest(r)
+	if err != nil {
+		writeErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e.mu.RLock()
+	config := e.config
+	e.mu.RUnlock()
+
+	if err = validateRoleARN(req.RoleARN, config.EcsAllowedRoleARNs); err != nil {
+		if errors.Is(err, errRoleARNRouteDisabled) {
+			log.Printf("Refused /role-arn/ request for %s: %s", req.RoleARN, err.Error())
+			e.roleRouteDisabledWarning.Do(func() {
+				fmt.Fprintf(os.Stderr, "aws-vault: warning: refused a request for role %s, the ECS server's /role-arn/ route is disabled until ecs_allowed_role_arns is set in profile %s\n", req.RoleARN, config.ProfileName)
+			})
+		}
+		writeErrorMessage(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	// The profile's session policies restrict every session, so requests can't replace them
+	if req.hasSessionPolicies() && config.HasSessionPolicies() {
+		writeErrorMessage(w, "Session policies can't be requested when the profile sets them", http.StatusBadRequest)
+		return
+	}
+
+	creds, err := e.getRoleProvider(req).Retrieve(r.Context())
+	if err != nil {
+		writeErrorMessage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeCredsToResponse(creds, w)
+}
+
+func (e *EcsServer) isProfileAllowed(profileName string) bool {
+	for _, p := range e.allowedProfiles {
+		if p == profileName {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *EcsServer) getProfileProvider(profileName string) (aws.CredentialsProvider, error) {
+	if v, ok := e.profileCache.Load(profileName); ok {
+		return v.(*aws.CredentialsCache), nil
+	}
+
+	// Serialise loading profiles so concurrent requests share a single provider per profile
+	e.profileMu.Lock()
+	defer e.profileMu.Unlock()
+
+	if v, ok := e.profileCache.Load(profileName); ok {
+		return v.(*aws.CredentialsCache), nil
+	}
+
+	e.mu.RLock()
+	configFile := e.configFile
+	e.mu.RUnlock()
+
+	config, err := vault.NewConfigLoader(e.profileConfig, configFile, profileName).GetProfileConfig(profileName)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading config: %w", err)
+	}
+
+	credsProvider, err := vault.NewTempCredentialsProvider(config, e.keyring, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting temporary credentials: %w", err)
+	}
+
+	profileProviderCache := aws.NewCredentialsCache(credsProvider)
+	e.profileCache.Store(profileName, profileProviderCache)
+
+	return profileProviderCache, nil
+}
+
+func (e *EcsServer) ProfileRoute(w http.ResponseWriter, r *http.Request) {
+	profileName := strings.TrimPrefix(r.URL.Path, "/profile/")
+	if !e.isProfileAllowed(profileName) {
+		writeErrorMessage(w, fmt.Sprintf("profile %q is not allowed", profileName), http.StatusForbidden)
+		return
+	}
+
+	profileProvider, err := e.getProfileProvider(profileName)
+	if err != nil {
+		writeErrorMessage(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	creds, err := profileProvider.Retrieve(r.Context())
 	if err != nil {
 		writeErrorMessage(w, err.Error(), http.StatusInternalServerError)
 		return
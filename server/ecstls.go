@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	ecsCACertFile   = "ca.pem"
+	ecsCAKeyFile    = "ca-key.pem"
+	ecsCABundleFile = "ca-bundle.pem"
+
+	ecsCAValidity   = 10 * 365 * 24 * time.Hour
+	ecsCertValidity = 365 * 24 * time.Hour
+)
+
+// systemCABundles are the usual locations of the system's CA bundle, as searched by crypto/x509
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian/Ubuntu/Gentoo etc.
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // Fedora/RHEL 6
+	"/etc/ssl/ca-bundle.pem",                            // OpenSUSE
+	"/etc/pki/tls/cacert.pem",                           // OpenELEC
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // CentOS/RHEL 7
+	"/etc/ssl/cert.pem",                                 // Alpine Linux, macOS
+}
+
+// loadOrCreateEcsCA loads the CA kept in dir, generating a new one if the directory doesn't have one
+func loadOrCreateEcsCA(dir string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, certErr := os.ReadFile(filepath.Join(dir, ecsCACertFile))
+	keyPEM, keyErr := os.ReadFile(filepath.Join(dir, ecsCAKeyFile))
+	if certErr == nil && keyErr == nil {
+		return parseEcsCA(certPEM, keyPEM)
+	}
+	if !errors.Is(certErr, os.ErrNotExist) && certErr != nil {
+		return nil, nil, certErr
+	}
+	if !errors.Is(keyErr, os.ErrNotExist) && keyErr != nil {
+		return nil, nil, keyErr
+	}
+
+	log.Printf("Generating a CA for the ECS server in %s", dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: "aws-vault local CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(ecsCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, err
+	}
+	if err = os.WriteFile(filepath.Join(dir, ecsCAKeyFile), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, nil, err
+	}
+	if err = os.WriteFile(filepath.Join(dir, ecsCACertFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func parseEcsCA(certPEM, keyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("no certificate found in %s", ecsCACertFile)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("no private key found in %s", ecsCAKeyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported private key in %s", ecsCAKeyFile)
+	}
+
+	return cert, signer, nil
+}
+
+// newEcsCertificate issues a server certificate for the given IPs and host names, signed by the CA
+func newEcsCertificate(ca *x509.Certificate, caKey crypto.Signer, ips []net.IP, dnsNames []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: "aws-vault ECS server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(ecsCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  ips,
+		DNSNames:     dnsNames,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der, ca.Raw},
+		PrivateKey:  key,
+	}, nil
+}
+
+// writeEcsCABundle writes the system's CA bundle, the existing bundles and then the local CA, so clients
+// that use it for all their requests can still reach AWS through the proxies the existing bundles trust
+func writeEcsCABundle(dir string, ca *x509.Certificate, existingBundles ...string) (string, error) {
+	path, err := filepath.Abs(filepath.Join(dir, ecsCABundleFile))
+	if err != nil {
+		return "", err
+	}
+
+	var bundle bytes.Buffer
+	seen := map[string]bool{}
+	addCerts := func(b []byte) {
+		for {
+			var block *pem.Block
+			block, b = pem.Decode(b)
+			if block == nil {
+				return
+			}
+			// A nested server's bundle may already hold these, so each certificate is only written once
+			if block.Type != "CERTIFICATE" || seen[string(block.Bytes)] {
+				continue
+			}
+			seen[string(block.Bytes)] = true
+			bundle.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: block.Bytes}))
+		}
+	}
+
+	systemBundles := systemCABundles
+	if f := os.Getenv("SSL_CERT_FILE"); f != "" {
+		systemBundles = append([]string{f}, systemBundles...)
+	}
+	found := false
+	for _, p := range systemBundles {
+		if b, err := os.ReadFile(p); err == nil {
+			addCerts(b)
+			found = true
+			break
+		}
+	}
+	if !found {
+		log.Println("No system CA bundle found, the CA bundle only contains the local CA")
+	}
+
+	for _, p := range existingBundles {
+		if p == "" {
+			continue
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return "", fmt.Errorf("reading CA bundle: %w", err)
+		}
+		addCerts(b)
+	}
+
+	addCerts(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))
+
+	if err = os.WriteFile(path, bundle.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func newSerialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return serial
+}
+
+// certificateHosts returns the IPs and names a server listening on ip can be reached by
+func certificateHosts(ip net.IP) ([]net.IP, []string) {
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	dnsNames := []string{"localhost", "host.docker.internal"}
+
+	if ip.IsUnspecified() {
+		if addrs, err := net.InterfaceAddrs(); err == nil {
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+					ips = append(ips, ipNet.IP)
+				}
+			}
+		}
+	} else if !ip.IsLoopback() {
+		ips = append(ips, ip)
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		dnsNames = append(dnsNames, hostname)
+	}
+
+	return ips, dnsNames
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestCA(t *testing.T) (*x509.Certificate, string) {
+	t.Helper()
+	dir := t.TempDir()
+	ca, _, err := loadOrCreateEcsCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, filepath.Join(dir, ecsCACertFile)
+}
+
+func readBundleCerts(t *testing.T, path string) []*x509.Certificate {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return certs
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func TestWriteEcsCABundleKeepsExistingBundles(t *testing.T) {
+	systemCA, systemBundle := newTestCA(t)
+	proxyCA, proxyBundle := newTestCA(t)
+	localCA, _ := newTestCA(t)
+	t.Setenv("SSL_CERT_FILE", systemBundle)
+
+	dir := t.TempDir()
+	path, err := writeEcsCABundle(dir, localCA, proxyBundle, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := readBundleCerts(t, path)
+	expected := []*x509.Certificate{systemCA, proxyCA, localCA}
+	if len(certs) != len(expected) {
+		t.Fatalf("Expected %d certificates, got %d", len(expected), len(certs))
+	}
+	for i, cert := range expected {
+		if !cert.Equal(certs[i]) {
+			t.Fatalf("Expected certificate %d to be %s, got %s", i, cert.Subject, certs[i].Subject)
+		}
+	}
+
+	// A nested server passes the bundle it inherited, which mustn't grow
+	if _, err = writeEcsCABundle(dir, localCA, path, proxyBundle); err != nil {
+		t.Fatal(err)
+	}
+	if certs = readBundleCerts(t, path); len(certs) != len(expected) {
+		t.Fatalf("Expected %d certificates after rewriting the bundle, got %d", len(expected), len(certs))
+	}
+}
+
+func TestWriteEcsCABundleMissingBundle(t *testing.T) {
+	localCA, _ := newTestCA(t)
+	if _, err := writeEcsCABundle(t.TempDir(), localCA, filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatal("Expected an error for a missing CA bundle")
+	}
+}
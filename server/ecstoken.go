@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEcsClientName is the name of the client that uses the token the server is created with
+	DefaultEcsClientName = "default"
+
+	// ecsTokenGraceWindow is how long the previous token is accepted after it is rotated
+	ecsTokenGraceWindow = 5 * time.Minute
+)
+
+type ecsClientToken struct {
+	name            string
+	file            string
+	current         string
+	previous        string
+	previousExpires time.Time
+}
+
+// ecsTokens holds the authorization tokens of the ECS server's clients
+type ecsTokens struct {
+	mu      sync.RWMutex
+	clients []*ecsClientToken
+}
+
+func (t *ecsTokens) add(name, token string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, c := range t.clients {
+		if c.name == name {
+			return fmt.Errorf("client %q already exists", name)
+		}
+	}
+
+	t.clients = append(t.clients, &ecsClientToken{name: name, current: token})
+	return nil
+}
+
+func (t *ecsTokens) find(name string) (*ecsClientToken, error) {
+	for _, c := range t.clients {
+		if c.name == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown client %q", name)
+}
+
+func (t *ecsTokens) token(name string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	c, err := t.find(name)
+	if err != nil {
+		return ""
+	}
+	return c.current
+}
+
+func (t *ecsTokens) setFile(name, path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, err := t.find(name)
+	if err != nil {
+		return err
+	}
+	c.file = path
+
+	return writeTokenFile(c.file, c.current)
+}
+
+// authorize returns the name of the client the token belongs to. Every token is compared, in constant time
+func (t *ecsTokens) authorize(token string) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := time.Now()
+	name := ""
+	for _, c := range t.clients {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.current)) == 1 {
+			name = c.name
+		}
+		if c.previous != "" && now.Before(c.previousExpires) && subtle.ConstantTimeCompare([]byte(token), []byte(c.previous)) == 1 {
+			name = c.name
+		}
+	}
+
+	return name, name != ""
+}
+
+// rotate replaces every client's token, accepting the previous token for the grace window
+func (t *ecsTokens) rotate(grace time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, c := range t.clients {
+		c.previous = c.current
+		c.previousExpires = time.Now().Add(grace)
+		c.current = generateRandomString()
+
+		if c.file != "" {
+			if err := writeTokenFile(c.file, c.current); err != nil {
+				log.Printf("Failed to write token for client %q: %s", c.name, err.Error())
+			}
+		}
+	}
+
+	log.Printf("Rotated authorization tokens, previous tokens are accepted for %s", grace)
+}
+
+// writeTokenFile writes the token to a private file, replacing it atomically so readers never see a partial token
+func writeTokenFile(path, token string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.WriteString(token); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+type ecsAuditEntry struct {
+	Time       string `json:"time"`
+	Client     string `json:"client"`
+	RemoteAddr string `json:"remote_addr"`
+	Process    string `json:"process,omitempty"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+}
+
+// ecsAuditLog writes an entry for each request to the ECS server as a line of JSON
+type ecsAuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (a *ecsAuditLog) write(r *http.Request, client string, status int) {
+	entry := ecsAuditEntry{
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		Client:     client,
+		RemoteAddr: r.RemoteAddr,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Status:     status,
+	}
+	if p, err := peerProcessFromRequest(r); err == nil {
+		entry.Process = p.String()
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err = a.w.Write(append(b, '\n')); err != nil {
+		log.Printf("Failed to write audit log: %s", err.Error())
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestEcsTokensAuthorize(t *testing.T) {
+	now := time.Now()
+	tokens := &ecsTokens{clients: []*ecsClientToken{
+		{name: "current", current: "token-current"},
+		{name: "grace", current: "token-grace-new", previous: "token-grace-old", previousExpires: now.Add(time.Minute)},
+		{name: "expired", current: "token-expired-new", previous: "token-expired-old", previousExpires: now.Add(-time.Second)},
+	}}
+
+	tests := []struct {
+		token  string
+		client string
+		ok     bool
+	}{
+		{"token-current", "current", true},
+		{"token-grace-new", "grace", true},
+		{"token-grace-old", "grace", true},
+		{"token-expired-new", "expired", true},
+		{"token-expired-old", "", false},
+		{"token-unknown", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		client, ok := tokens.authorize(tt.token)
+		if client != tt.client || ok != tt.ok {
+			t.Errorf("authorize(%q) = %q, %v, expected %q, %v", tt.token, client, ok, tt.client, tt.ok)
+		}
+	}
+}
+
+func TestEcsTokensRotate(t *testing.T) {
+	tests := []struct {
+		name       string
+		grace      time.Duration
+		previousOK bool
+	}{
+		{"within the grace window", ecsTokenGraceWindow, true},
+		{"without a grace window", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := &ecsTokens{}
+			if err := tokens.add(DefaultEcsClientName, "token-1"); err != nil {
+				t.Fatal(err)
+			}
+
+			tokens.rotate(tt.grace)
+
+			rotated := tokens.token(DefaultEcsClientName)
+			if rotated == "" || rotated == "token-1" {
+				t.Fatalf("Expected a new token, got %q", rotated)
+			}
+			if _, ok := tokens.authorize(rotated); !ok {
+				t.Fatal("Expected the rotated token to be accepted")
+			}
+			if _, ok := tokens.authorize("token-1"); ok != tt.previousOK {
+				t.Fatalf("Expected the previous token to be accepted: %v, got %v", tt.previousOK, ok)
+			}
+		})
+	}
+}
+
+func TestEcsTokensFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+
+	tokens := &ecsTokens{}
+	if err := tokens.add(DefaultEcsClientName, "token-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.setFile("unknown", path); err == nil {
+		t.Fatal("Expected an error for an unknown client")
+	}
+	if err := tokens.setFile(DefaultEcsClientName, path); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && before.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the token file to be private, got %s", before.Mode().Perm())
+	}
+	if b, _ := os.ReadFile(path); string(b) != "token-1" {
+		t.Fatalf("Expected the token in the file, got %q", b)
+	}
+
+	tokens.rotate(ecsTokenGraceWindow)
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Fatal("Expected the token file to be replaced rather than rewritten")
+	}
+	if runtime.GOOS != "windows" && after.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the rotated token file to be private, got %s", after.Mode().Perm())
+	}
+	if b, _ := os.ReadFile(path); string(b) != tokens.token(DefaultEcsClientName) {
+		t.Fatalf("Expected the rotated token in the file, got %q", b)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the token file to be left, got %d files", len(entries))
+	}
+}
@@ -19,8 +19,9 @@ func (w *loggingMiddlewareResponseWriter) WriteHeader(statusCode int) {
 func withLogging(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestStart := time.Now()
+		r = withPeerProcessLookup(r)
 		w2 := &loggingMiddlewareResponseWriter{w, http.StatusOK}
 		handler.ServeHTTP(w2, r)
-		log.Printf("http: %s: %d %s %s (%s)", r.RemoteAddr, w2.Code, r.Method, r.URL, time.Since(requestStart))
+		log.Printf("http: %s: %d %s %s (%s)", describePeer(r), w2.Code, r.Method, r.URL, time.Since(requestStart))
 	})
 }
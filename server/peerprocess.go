@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ec2ProxyClientHeader is set by the EC2 metadata endpoint proxy to the address of the connection it forwards
+const ec2ProxyClientHeader = "X-Aws-Vault-Proxy-Client"
+
+// PeerProcess is the local process at the other end of a connection
+type PeerProcess struct {
+	PID        int
+	Executable string
+
+	// Ancestors are the PIDs of the process's parent, grandparent and so on
+	Ancestors []int
+}
+
+func (p *PeerProcess) String() string {
+	return fmt.Sprintf("pid %d %s", p.PID, p.Executable)
+}
+
+// ProcessAllowlist restricts which local processes may receive credentials. A process must match
+// every restriction that is set
+type ProcessAllowlist struct {
+	// Executables are glob patterns matched against the executable's path, or against its base name
+	// for patterns without a slash
+	Executables []string
+
+	// AncestorPID only allows descendants of the process with this PID
+	AncestorPID int
+}
+
+func (a *ProcessAllowlist) allows(p *PeerProcess) bool {
+	if len(a.Executables) > 0 && !matchesExecutable(a.Executables, p.Executable) {
+		return false
+	}
+
+	if a.AncestorPID != 0 {
+		for _, pid := range p.Ancestors {
+			if pid == a.AncestorPID {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func matchesExecutable(patterns []string, executable string) bool {
+	if executable == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		name := executable
+		if !strings.Contains(pattern, "/") {
+			name = filepath.Base(executable)
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type peerProcessLookup struct {
+	once    sync.Once
+	r       *http.Request
+	process *PeerProcess
+	err     error
+}
+
+type peerProcessLookupKey struct{}
+
+// withPeerProcessLookup adds a lookup of the request's peer process to the request context, so the
+// process is only identified once, and only if needed
+func withPeerProcessLookup(r *http.Request) *http.Request {
+	l := &peerProcessLookup{r: r}
+	return r.WithContext(context.WithValue(r.Context(), peerProcessLookupKey{}, l))
+}
+
+func peerProcessFromRequest(r *http.Request) (*PeerProcess, error) {
+	l, ok := r.Context().Value(peerProcessLookupKey{}).(*peerProcessLookup)
+	if !ok {
+		return lookupPeerProcess(r)
+	}
+
+	l.once.Do(func() {
+		l.process, l.err = lookupPeerProcess(l.r)
+	})
+	return l.process, l.err
+}
+
+// describePeer returns the request's remote address and, when logging, the process that made the request
+func describePeer(r *http.Request) string {
+	if log.Writer() == io.Discard {
+		return r.RemoteAddr
+	}
+
+	p, err := peerProcessFromRequest(r)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return fmt.Sprintf("%s (%s)", r.RemoteAddr, p)
+}
+
+// withProcessCheck is middleware that only allows requests from processes in the allowlist
+func withProcessCheck(allowlist *ProcessAllowlist, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowlist == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := peerProcessFromRequest(r)
+		if err != nil {
+			log.Printf("Denying request from %s: couldn't identify the process: %s", r.RemoteAddr, err.Error())
+			http.Error(w, "Access denied: couldn't identify the requesting process", http.StatusForbidden)
+			return
+		}
+		if !allowlist.allows(p) {
+			log.Printf("Denying request from %s: process %s isn't allowed", r.RemoteAddr, p)
+			http.Error(w, fmt.Sprintf("Access denied for process %s", p), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"errors"
+	"net/http"
+)
+
+func lookupPeerProcess(r *http.Request) (*PeerProcess, error) {
+	return nil, errors.New("identifying processes is only supported on Linux")
+}
@@ -0,0 +1,205 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lookupPeerProcess identifies the local process that made the request, using the connection's
+// entry in /proc/net/tcp and the socket file descriptors in /proc/<pid>/fd
+func lookupPeerProcess(r *http.Request) (*PeerProcess, error) {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return nil, errors.New("unknown local address")
+	}
+
+	client, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	server, err := net.ResolveTCPAddr("tcp", local.String())
+	if err != nil {
+		return nil, err
+	}
+
+	uid, inode, err := findTCPSocket(client, server)
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests forwarded by the EC2 metadata endpoint proxy, which runs as root, identify the connection
+	// the proxy accepted. The header is ignored from any other process
+	if forwarded := r.Header.Get(ec2ProxyClientHeader); forwarded != "" && uid == 0 {
+		client, err = net.ResolveTCPAddr("tcp", forwarded)
+		if err != nil {
+			return nil, err
+		}
+		server, err = net.ResolveTCPAddr("tcp", ec2MetadataEndpointAddr)
+		if err != nil {
+			return nil, err
+		}
+		if _, inode, err = findTCPSocket(client, server); err != nil {
+			return nil, err
+		}
+	}
+
+	pid, err := findSocketProcess(inode)
+	if err != nil {
+		return nil, err
+	}
+
+	exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+
+	return &PeerProcess{
+		PID:        pid,
+		Executable: exe,
+		Ancestors:  processAncestors(pid),
+	}, nil
+}
+
+// findTCPSocket returns the owner and inode of the socket connected from client to server
+func findTCPSocket(client, server *net.TCPAddr) (uid int, inode uint64, err error) {
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		uid, inode, err = findTCPSocketInFile(path, client, server)
+		if err == nil {
+			return uid, inode, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("no socket found for connection from %s", client)
+}
+
+func findTCPSocketInFile(path string, client, server *net.TCPAddr) (int, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // skip the header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		localIP, localPort, err := parseProcNetAddr(fields[1])
+		if err != nil || localPort != client.Port || !localIP.Equal(client.IP) {
+			continue
+		}
+		remoteIP, remotePort, err := parseProcNetAddr(fields[2])
+		if err != nil || remotePort != server.Port || !remoteIP.Equal(server.IP) {
+			continue
+		}
+
+		uid, err := strconv.Atoi(fields[7])
+		if err != nil {
+			return 0, 0, err
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		return uid, inode, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, errors.New("not found")
+}
+
+// parseProcNetAddr parses an address like 0100007F:1F90, where the IP is hex encoded in
+// 32 bit words in host byte order and the port is hex encoded
+func parseProcNetAddr(s string) (net.IP, int, error) {
+	ipHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+
+	b, err := hex.DecodeString(ipHex)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		binary.NativeEndian.PutUint32(b[i:], binary.BigEndian.Uint32(b[i:]))
+	}
+
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+
+	return net.IP(b), int(port), nil
+}
+
+// findSocketProcess returns the PID of a process with the socket open. Only processes
+// that the current user can inspect are found
+func findSocketProcess(inode uint64) (int, error) {
+	target := fmt.Sprintf("socket:[%d]", inode)
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+
+		fdDir := filepath.Join("/proc", e.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if link, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && link == target {
+				return pid, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("no process found for socket %d", inode)
+}
+
+// processAncestors returns the PIDs of the process's parent, grandparent and so on
+func processAncestors(pid int) []int {
+	ancestors := []int{}
+	for pid > 1 {
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			break
+		}
+
+		// The command name in brackets can contain spaces, so the fields start after the last bracket
+		i := strings.LastIndexByte(string(stat), ')')
+		if i < 0 {
+			break
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) < 2 {
+			break
+		}
+
+		pid, err = strconv.Atoi(fields[1])
+		if err != nil || pid == 0 {
+			break
+		}
+		ancestors = append(ancestors, pid)
+	}
+	return ancestors
+}
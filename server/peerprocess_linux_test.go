@@ -0,0 +1,106 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures are written by a little-endian kernel, which prints each 32 bit word of an address reversed
+func skipUnlessLittleEndian(t *testing.T) {
+	t.Helper()
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, 1)
+	if b[0] != 1 {
+		t.Skip("the /proc/net fixtures are little-endian")
+	}
+}
+
+func TestParseProcNetAddr(t *testing.T) {
+	skipUnlessLittleEndian(t)
+
+	tests := []struct {
+		s    string
+		ip   string
+		port int
+	}{
+		{"0100007F:1F90", "127.0.0.1", 8080},
+		{"FEA9FEA9:0050", "169.254.169.254", 80},
+		{"0101A8C0:C350", "192.168.1.1", 50000},
+		{"00000000000000000000000001000000:0050", "::1", 80},
+		{"0000000000000000FFFF00000100007F:1F90", "127.0.0.1", 8080},
+		{"B80D0120000000000000000001000000:01BB", "2001:db8::1", 443},
+	}
+	for _, tt := range tests {
+		ip, port, err := parseProcNetAddr(tt.s)
+		if err != nil {
+			t.Errorf("parseProcNetAddr(%q) failed: %v", tt.s, err)
+			continue
+		}
+		if !ip.Equal(net.ParseIP(tt.ip)) || port != tt.port {
+			t.Errorf("parseProcNetAddr(%q) = %s:%d, expected %s:%d", tt.s, ip, port, tt.ip, tt.port)
+		}
+	}
+
+	for _, s := range []string{"", "0100007F", "0100007F:", "ZZ00007F:1F90", "01007F:1F90", "0100007F:1FFFF"} {
+		if _, _, err := parseProcNetAddr(s); err == nil {
+			t.Errorf("Expected parseProcNetAddr(%q) to fail", s)
+		}
+	}
+}
+
+func TestFindTCPSocketInFile(t *testing.T) {
+	skipUnlessLittleEndian(t)
+
+	dir := t.TempDir()
+	tcp := filepath.Join(dir, "tcp")
+	err := os.WriteFile(tcp, []byte(`  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 11111 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:C350 0100007F:1F90 01 00000000:00000000 00:00000000 00000000  1000        0 22222 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:1F90 0100007F:C350 01 00000000:00000000 00:00000000 00000000  1000        0 33333 1 0000000000000000 20 4 30 10 -1
+   3: 0101A8C0:C351 FEA9FEA9:0050 01 00000000:00000000 00:00000000 00000000     0        0 44444 1 0000000000000000 20 4 30 10 -1
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp6 := filepath.Join(dir, "tcp6")
+	err = os.WriteFile(tcp6, []byte(`  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:C352 00000000000000000000000001000000:1F90 01 00000000:00000000 00:00000000 00000000   501        0 55555 1 0000000000000000 20 4 30 10 -1
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080}
+	tests := []struct {
+		path   string
+		client *net.TCPAddr
+		server *net.TCPAddr
+		uid    int
+		inode  uint64
+	}{
+		{tcp, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 50000}, server, 1000, 22222},
+		{tcp, &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 50001}, &net.TCPAddr{IP: net.ParseIP("169.254.169.254"), Port: 80}, 0, 44444},
+		{tcp6, &net.TCPAddr{IP: net.ParseIP("::1"), Port: 50002}, &net.TCPAddr{IP: net.ParseIP("::1"), Port: 8080}, 501, 55555},
+	}
+	for _, tt := range tests {
+		uid, inode, err := findTCPSocketInFile(tt.path, tt.client, tt.server)
+		if err != nil {
+			t.Errorf("Expected a socket from %s, got %v", tt.client, err)
+			continue
+		}
+		if uid != tt.uid || inode != tt.inode {
+			t.Errorf("Expected uid %d inode %d for %s, got uid %d inode %d", tt.uid, tt.inode, tt.client, uid, inode)
+		}
+	}
+
+	// The server's own end of the connection isn't the client's socket
+	if _, _, err = findTCPSocketInFile(tcp, server, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1}); err == nil {
+		t.Error("Expected no socket for an unknown connection")
+	}
+}
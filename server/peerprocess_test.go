@@ -0,0 +1,36 @@
+package server
+
+import "testing"
+
+func TestProcessAllowlist(t *testing.T) {
+	process := &PeerProcess{PID: 300, Executable: "/usr/local/bin/terraform", Ancestors: []int{200, 100, 1}}
+
+	tests := []struct {
+		name      string
+		allowlist ProcessAllowlist
+		process   *PeerProcess
+		ok        bool
+	}{
+		{"no restrictions", ProcessAllowlist{}, process, true},
+		{"base name", ProcessAllowlist{Executables: []string{"terraform"}}, process, true},
+		{"base name glob", ProcessAllowlist{Executables: []string{"aws", "terra*"}}, process, true},
+		{"full path", ProcessAllowlist{Executables: []string{"/usr/local/bin/terraform"}}, process, true},
+		{"path glob", ProcessAllowlist{Executables: []string{"/usr/local/bin/*"}}, process, true},
+		{"path glob doesn't cross directories", ProcessAllowlist{Executables: []string{"/usr/*/terraform"}}, process, false},
+		{"path glob in another directory", ProcessAllowlist{Executables: []string{"/usr/bin/*"}}, process, false},
+		{"other executable", ProcessAllowlist{Executables: []string{"aws"}}, process, false},
+		{"unknown executable", ProcessAllowlist{Executables: []string{"*"}}, &PeerProcess{PID: 300}, false},
+		{"parent", ProcessAllowlist{AncestorPID: 200}, process, true},
+		{"grandparent", ProcessAllowlist{AncestorPID: 100}, process, true},
+		{"not an ancestor", ProcessAllowlist{AncestorPID: 400}, process, false},
+		{"the process itself isn't its ancestor", ProcessAllowlist{AncestorPID: 300}, process, false},
+		{"executable and ancestor", ProcessAllowlist{Executables: []string{"terraform"}, AncestorPID: 100}, process, true},
+		{"executable but not ancestor", ProcessAllowlist{Executables: []string{"terraform"}, AncestorPID: 400}, process, false},
+		{"ancestor but not executable", ProcessAllowlist{Executables: []string{"aws"}, AncestorPID: 100}, process, false},
+	}
+	for _, tt := range tests {
+		if ok := tt.allowlist.allows(tt.process); ok != tt.ok {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.ok, ok)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// defaultRequestTimeout is how long a request to AWS may take, including retries, unless the profile
+// sets aws_vault_request_timeout
+const defaultRequestTimeout = 1 * time.Minute
+
+// requestTimeout returns how long each request may take, for requests made both with and without the SDK
+func (c *ProfileConfig) requestTimeout() time.Duration {
+	if c.RequestTimeout == 0 {
+		return defaultRequestTimeout
+	}
+	return c.RequestTimeout
+}
+
+// apiOptions returns the middleware that applies the profile's request timeout and STS failover regions
+func (c *ProfileConfig) apiOptions(region string) []func(*middleware.Stack) error {
+	timeout := c.requestTimeout()
+
+	// The failover is added first so that it's outside the timeout, and each region gets the full timeout
+	options := []func(*middleware.Stack) error{}
+	if regions := c.stsFailoverRegions(region); len(regions) > 0 {
+		options = append(options, func(stack *middleware.Stack) error {
+			if err := stack.Initialize.Add(&stsFailover{regions: regions}, middleware.After); err != nil {
+				return err
+			}
+			return stack.Finalize.Insert(&stsFailoverEndpoint{
+				options: sts.EndpointResolverOptions{
+					UseFIPSEndpoint:      c.UseFIPSEndpoint,
+					UseDualStackEndpoint: c.UseDualStackEndpoint,
+				},
+			}, "ResolveEndpointV2", middleware.After)
+		})
+	}
+	options = append(options, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(requestTimeout(timeout), middleware.After)
+	})
+
+	return options
+}
+
+// stsFailoverRegions returns the profile's STS failover regions that are in the same partition as region.
+// There are none when STS has a custom endpoint, as it's the same for every region
+func (c *ProfileConfig) stsFailoverRegions(region string) []string {
+	if len(c.STSFailoverRegions) == 0 || region == "" || c.EndpointURLFor(sts.ServiceID) != "" {
+		return nil
+	}
+
+	resolver := sts.NewDefaultEndpointResolver()
+	primary, err := resolver.ResolveEndpoint(region, sts.EndpointResolverOptions{})
+	if err != nil {
+		return nil
+	}
+
+	regions := []string{}
+	for _, r := range c.STSFailoverRegions {
+		if r == region {
+			continue
+		}
+		endpoint, err := resolver.ResolveEndpoint(r, sts.EndpointResolverOptions{})
+		if err != nil || endpoint.PartitionID != primary.PartitionID {
+			log.Printf("Ignoring STS failover region %s, which isn't in the same partition as %s", r, region)
+			continue
+		}
+		regions = append(regions, r)
+	}
+	return regions
+}
+
+func requestTimeout(timeout time.Duration) middleware.InitializeMiddleware {
+	return middleware.InitializeMiddlewareFunc("AwsVaultRequestTimeout", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return next.HandleInitialize(ctx, in)
+	})
+}
+
+// failoverRegionKey holds the region an STS request is being sent to instead of the client's region
+type failoverRegionKey struct{}
+
+// stsFailover retries STS requests in the failover regions in turn, when the request can't be sent
+// or the regional endpoint returns a server error
+type stsFailover struct {
+	regions []string
+}
+
+func (*stsFailover) ID() string {
+	return "AwsVaultSTSFailover"
+}
+
+func (m *stsFailover) HandleInitialize(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	out, metadata, err := next.HandleInitialize(ctx, in)
+	if awsmiddleware.GetServiceID(ctx) != sts.ServiceID {
+		return out, metadata, err
+	}
+
+	region := awsmiddleware.GetRegion(ctx)
+	for _, failoverRegion := range m.regions {
+		if !isServiceUnavailable(ctx, err) {
+			break
+		}
+		log.Printf("STS %s failed in %s, trying %s: %s", awsmiddleware.GetOperationName(ctx), region, failoverRegion, err.Error())
+		region = failoverRegion
+		out, metadata, err = next.HandleInitialize(context.WithValue(ctx, failoverRegionKey{}, failoverRegion), in)
+	}
+	return out, metadata, err
+}
+
+// isServiceUnavailable returns whether err means the endpoint couldn't be reached or had a server error,
+// rather than that the request was rejected or cancelled
+func isServiceUnavailable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	var sendErr *smithyhttp.RequestSendError
+	if errors.As(err, &sendErr) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() >= 500
+}
+
+// stsFailoverEndpoint sends the request to the STS endpoint of the failover region, as the endpoint
+// resolver only knows the client's region. It runs after the SDK has resolved the endpoint
+type stsFailoverEndpoint struct {
+	options sts.EndpointResolverOptions
+}
+
+func (*stsFailoverEndpoint) ID() string {
+	return "AwsVaultSTSFailoverEndpoint"
+}
+
+func (m *stsFailoverEndpoint) HandleFinalize(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	region, ok := ctx.Value(failoverRegionKey{}).(string)
+	if !ok {
+		return next.HandleFinalize(ctx, in)
+	}
+
+	req, ok := in.Request.(*smithyhttp.Request)
+	if !ok {
+		return middleware.FinalizeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected request type %T", in.Request)
+	}
+
+	endpoint, err := sts.NewDefaultEndpointResolver().ResolveEndpoint(region, m.options)
+	if err != nil {
+		return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+	}
+	u, err := url.Parse(endpoint.URL)
+	if err != nil {
+		return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+	}
+	req.URL.Scheme = u.Scheme
+	req.URL.Host = u.Host
+
+	signingRegion := endpoint.SigningRegion
+	if signingRegion == "" {
+		signingRegion = region
+	}
+	ctx = awsmiddleware.SetSigningRegion(ctx, signingRegion)
+
+	return next.HandleFinalize(ctx, in)
+}
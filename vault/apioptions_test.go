@@ -0,0 +1,143 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) Do(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+const getCallerIdentityResponse = `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:iam::111111111111:user/test</Arn>
+    <UserId>AIDATEST</UserId>
+    <Account>111111111111</Account>
+  </GetCallerIdentityResult>
+</GetCallerIdentityResponse>`
+
+func TestSTSFailoverRegions(t *testing.T) {
+	config := &ProfileConfig{STSFailoverRegions: []string{"us-east-1", "us-west-2", "cn-north-1", "eu-west-1"}}
+
+	regions := config.stsFailoverRegions("us-east-1")
+	if expected := []string{"us-west-2", "eu-west-1"}; !reflect.DeepEqual(expected, regions) {
+		t.Fatalf("Expected %v, got %v", expected, regions)
+	}
+
+	config.STSEndpointURL = "http://localhost:4566"
+	if regions := config.stsFailoverRegions("us-east-1"); len(regions) != 0 {
+		t.Fatalf("Expected no failover with a custom STS endpoint, got %v", regions)
+	}
+}
+
+func TestSTSFailover(t *testing.T) {
+	hosts := []string{}
+	config := &ProfileConfig{STSFailoverRegions: []string{"us-west-2"}, MaxAttempts: 1}
+	cfg := NewAwsConfigWithCredsProvider(credentials.NewStaticCredentialsProvider("AKIATEST", "secret", ""), "us-east-1", config)
+	cfg.HTTPClient = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		hosts = append(hosts, r.URL.Host)
+		if r.URL.Host == "sts.us-east-1.amazonaws.com" {
+			return nil, errors.New("connection refused")
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "/us-west-2/sts/") {
+			t.Errorf("Expected the request to be signed for us-west-2, got %q", r.Header.Get("Authorization"))
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(getCallerIdentityResponse)),
+		}, nil
+	})
+
+	_, err := sts.NewFromConfig(cfg).GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"sts.us-east-1.amazonaws.com", "sts.us-west-2.amazonaws.com"}; !reflect.DeepEqual(expected, hosts) {
+		t.Fatalf("Expected requests to %v, got %v", expected, hosts)
+	}
+}
+
+func TestSTSFailoverSkipsRejectedRequests(t *testing.T) {
+	requests := 0
+	config := &ProfileConfig{STSFailoverRegions: []string{"us-west-2"}, MaxAttempts: 1}
+	cfg := NewAwsConfigWithCredsProvider(credentials.NewStaticCredentialsProvider("AKIATEST", "secret", ""), "us-east-1", config)
+	cfg.HTTPClient = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`<ErrorResponse><Error><Code>AccessDenied</Code></Error></ErrorResponse>`)),
+		}, nil
+	})
+
+	_, err := sts.NewFromConfig(cfg).GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if requests != 1 {
+		t.Fatalf("Expected 1 request, got %d", requests)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	config := &ProfileConfig{RequestTimeout: 50 * time.Millisecond, MaxAttempts: 1}
+	cfg := NewAwsConfigWithCredsProvider(credentials.NewStaticCredentialsProvider("AKIATEST", "secret", ""), "us-east-1", config)
+	cfg.HTTPClient = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	})
+
+	start := time.Now()
+	_, err := sts.NewFromConfig(cfg).GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a deadline exceeded error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the request to time out after 50ms, took %s", elapsed)
+	}
+}
+
+func TestRequestTimeoutWithoutSDK(t *testing.T) {
+	if timeout := (&ProfileConfig{}).HTTPClient().Timeout; timeout != defaultRequestTimeout {
+		t.Fatalf("Expected the default request timeout, got %s", timeout)
+	}
+
+	config := &ProfileConfig{RequestTimeout: 50 * time.Millisecond}
+	if timeout := config.HTTPClient().Timeout; timeout != config.RequestTimeout {
+		t.Fatalf("Expected a request timeout of 50ms, got %s", timeout)
+	}
+
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	start := time.Now()
+	_, err := fetchSAMLAssertionFromIdp(context.Background(), config.HTTPClient(), ts.URL)
+	if err == nil {
+		t.Fatal("Expected the SAML IdP request to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the request to time out after 50ms, took %s", elapsed)
+	}
+}
@@ -21,6 +21,8 @@ type AssumeRoleProvider struct {
 	Tags              map[string]string
 	TransitiveTagKeys []string
 	SourceIdentity    string
+	PolicyARNs        []string
+	Policy            string
 	Mfa
 }
 
@@ -89,6 +91,11 @@ func (p *AssumeRoleProvider) RetrieveStsCredentials(ctx context.Context) (*ststy
 		input.SourceIdentity = aws.String(p.SourceIdentity)
 	}
 
+	input.PolicyArns = policyDescriptors(p.PolicyARNs)
+	if p.Policy != "" {
+		input.Policy = aws.String(p.Policy)
+	}
+
 	resp, err := p.StsClient.AssumeRole(ctx, input)
 	if err != nil {
 		return nil, err
@@ -98,3 +105,16 @@ func (p *AssumeRoleProvider) RetrieveStsCredentials(ctx context.Context) (*ststy
 
 	return resp.Credentials, nil
 }
+
+// policyDescriptors converts managed policy ARNs to the type used by STS
+func policyDescriptors(arns []string) []ststypes.PolicyDescriptorType {
+	if len(arns) == 0 {
+		return nil
+	}
+
+	descriptors := make([]ststypes.PolicyDescriptorType, 0, len(arns))
+	for _, arn := range arns {
+		descriptors = append(descriptors, ststypes.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+	return descriptors
+}
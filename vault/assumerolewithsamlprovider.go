@@ -0,0 +1,259 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/99designs/aws-vault/v7/prompt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+const samlRoleAttributeName = "https://aws.amazon.com/SAML/Attributes/Role"
+
+// SAMLRole is a role and SAML provider pair that a SAML assertion allows
+type SAMLRole struct {
+	RoleARN      string
+	PrincipalARN string
+}
+
+// AssumeRoleWithSAMLProvider retrieves temporary credentials from STS using AssumeRoleWithSAML
+type AssumeRoleWithSAMLProvider struct {
+	StsClient            *sts.Client
+	RoleARN              string
+	PrincipalARN         string
+	SAMLAssertionProcess string
+	SAMLIdpURL           string
+	Duration             time.Duration
+	PolicyARNs           []string
+	Policy               string
+	HTTPClient           *http.Client
+
+	// rolePromptFunc asks which role to assume when the assertion allows several and RoleARN isn't set
+	rolePromptFunc func([]SAMLRole) (SAMLRole, error)
+}
+
+// Retrieve generates a new set of temporary credentials using STS AssumeRoleWithSAML
+func (p *AssumeRoleWithSAMLProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
+
+func (p *AssumeRoleWithSAMLProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	assertion, err := p.samlAssertion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := parseSAMLRoles(assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := p.chooseRole(roles)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &sts.AssumeRoleWithSAMLInput{
+		RoleArn:         aws.String(role.RoleARN),
+		PrincipalArn:    aws.String(role.PrincipalARN),
+		SAMLAssertion:   aws.String(assertion),
+		DurationSeconds: aws.Int32(int32(p.Duration.Seconds())),
+		PolicyArns:      policyDescriptors(p.PolicyARNs),
+	}
+	if p.Policy != "" {
+		input.Policy = aws.String(p.Policy)
+	}
+
+	resp, err := p.StsClient.AssumeRoleWithSAML(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Generated credentials %s using AssumeRoleWithSAML, expires in %s", FormatKeyForDisplay(*resp.Credentials.AccessKeyId), time.Until(*resp.Credentials.Expiration).String())
+
+	return resp.Credentials, nil
+}
+
+// chooseRole selects the role to assume from the roles allowed by the assertion
+func (p *AssumeRoleWithSAMLProvider) chooseRole(roles []SAMLRole) (SAMLRole, error) {
+	if p.RoleARN != "" {
+		for _, r := range roles {
+			if r.RoleARN == p.RoleARN && (p.PrincipalARN == "" || r.PrincipalARN == p.PrincipalARN) {
+				return r, nil
+			}
+		}
+		if p.PrincipalARN != "" {
+			return SAMLRole{RoleARN: p.RoleARN, PrincipalARN: p.PrincipalARN}, nil
+		}
+		return SAMLRole{}, fmt.Errorf("role %s is not allowed by the SAML assertion", p.RoleARN)
+	}
+
+	if len(roles) == 0 {
+		return SAMLRole{}, errors.New("SAML assertion doesn't contain any roles")
+	}
+	if len(roles) == 1 {
+		return roles[0], nil
+	}
+
+	if p.rolePromptFunc == nil {
+		return SAMLRole{}, errors.New("the SAML assertion allows several roles, set role_arn to choose one")
+	}
+	return p.rolePromptFunc(roles)
+}
+
+func terminalSAMLRolePrompt(roles []SAMLRole) (SAMLRole, error) {
+	choices := []string{}
+	for _, r := range roles {
+		choices = append(choices, r.RoleARN)
+	}
+
+	i, err := prompt.TerminalChoicePrompt("Choose a role allowed by the SAML assertion: ", choices)
+	if err != nil {
+		return SAMLRole{}, err
+	}
+
+	return roles[i], nil
+}
+
+func (p *AssumeRoleWithSAMLProvider) samlAssertion(ctx context.Context) (string, error) {
+	if p.SAMLAssertionProcess != "" {
+		out, err := executeProcess(p.SAMLAssertionProcess)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(out), nil
+	}
+
+	return fetchSAMLAssertionFromIdp(ctx, p.HTTPClient, p.SAMLIdpURL)
+}
+
+var htmlInputTagRegexp = regexp.MustCompile(`(?is)<input\b[^>]*>`)
+var htmlAttrRegexp = regexp.MustCompile(`(?is)\b(name|value)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// fetchSAMLAssertionFromIdp requests an IdP-initiated sign-on URL and returns the
+// SAMLResponse from the form the IdP posts to the AWS sign-in endpoint
+func fetchSAMLAssertionFromIdp(ctx context.Context, httpClient *http.Client, idpURL string) (string, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return "", err
+	}
+	// A copy of the client, so the IdP's cookies are kept for its redirects only
+	client := *httpClient
+	client.Jar = jar
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, idpURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Requesting SAML assertion from %s", idpURL)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("request to SAML IdP %s failed with %s", idpURL, resp.Status)
+	}
+
+	assertion := samlResponseFromHTML(string(body))
+	if assertion == "" {
+		return "", fmt.Errorf("no SAMLResponse found in the response from %s. If your IdP requires an interactive login, use saml_assertion_process instead", idpURL)
+	}
+
+	return assertion, nil
+}
+
+func samlResponseFromHTML(body string) string {
+	for _, tag := range htmlInputTagRegexp.FindAllString(body, -1) {
+		var name, value string
+		for _, m := range htmlAttrRegexp.FindAllStringSubmatch(tag, -1) {
+			v := m[2] + m[3]
+			if strings.EqualFold(m[1], "name") {
+				name = v
+			} else {
+				value = v
+			}
+		}
+		if name == "SAMLResponse" {
+			return html.UnescapeString(value)
+		}
+	}
+
+	return ""
+}
+
+type samlAssertionDocument struct {
+	Attributes []struct {
+		Name   string   `xml:"Name,attr"`
+		Values []string `xml:"AttributeValue"`
+	} `xml:"Assertion>AttributeStatement>Attribute"`
+}
+
+// parseSAMLRoles returns the roles listed in a base64 encoded SAML response
+func parseSAMLRoles(assertion string) ([]SAMLRole, error) {
+	b, err := base64.StdEncoding.DecodeString(assertion)
+	if err != nil {
+		return nil, fmt.Errorf("SAML assertion is not valid base64: %w", err)
+	}
+
+	var doc samlAssertionDocument
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("SAML assertion is not valid XML: %w", err)
+	}
+
+	roles := []SAMLRole{}
+	for _, attr := range doc.Attributes {
+		if attr.Name != samlRoleAttributeName {
+			continue
+		}
+		for _, v := range attr.Values {
+			var role SAMLRole
+			// The role and principal ARNs can appear in either order
+			for _, arn := range strings.Split(strings.TrimSpace(v), ",") {
+				arn = strings.TrimSpace(arn)
+				if strings.Contains(arn, ":saml-provider/") {
+					role.PrincipalARN = arn
+				} else if strings.Contains(arn, ":role/") {
+					role.RoleARN = arn
+				}
+			}
+			if role.RoleARN == "" || role.PrincipalARN == "" {
+				return nil, fmt.Errorf("invalid SAML role attribute value %q", v)
+			}
+			roles = append(roles, role)
+		}
+	}
+
+	return roles, nil
+}
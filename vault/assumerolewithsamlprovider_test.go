@@ -0,0 +1,182 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/99designs/keyring"
+)
+
+const testSAMLResponse = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">
+  <saml:Assertion>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="https://aws.amazon.com/SAML/Attributes/RoleSessionName">
+        <saml:AttributeValue>jon@example.com</saml:AttributeValue>
+      </saml:Attribute>
+      <saml:Attribute Name="https://aws.amazon.com/SAML/Attributes/Role">
+        <saml:AttributeValue>arn:aws:iam::111111111111:role/admin,arn:aws:iam::111111111111:saml-provider/adfs</saml:AttributeValue>
+        <saml:AttributeValue>arn:aws:iam::222222222222:saml-provider/adfs,arn:aws:iam::222222222222:role/readonly</saml:AttributeValue>
+      </saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`
+
+var testSAMLRoles = []SAMLRole{
+	{RoleARN: "arn:aws:iam::111111111111:role/admin", PrincipalARN: "arn:aws:iam::111111111111:saml-provider/adfs"},
+	{RoleARN: "arn:aws:iam::222222222222:role/readonly", PrincipalARN: "arn:aws:iam::222222222222:saml-provider/adfs"},
+}
+
+func TestParseSAMLRoles(t *testing.T) {
+	roles, err := parseSAMLRoles(base64.StdEncoding.EncodeToString([]byte(testSAMLResponse)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roles, testSAMLRoles) {
+		t.Fatalf("Expected %v, got %v", testSAMLRoles, roles)
+	}
+
+	if _, err := parseSAMLRoles("not base64!"); err == nil {
+		t.Fatal("Expected an error for an invalid assertion")
+	}
+}
+
+func TestAssumeRoleWithSAMLProviderChooseRole(t *testing.T) {
+	prompted := SAMLRole{}
+	promptFunc := func(roles []SAMLRole) (SAMLRole, error) {
+		prompted = roles[1]
+		return roles[1], nil
+	}
+
+	tests := []struct {
+		name     string
+		provider AssumeRoleWithSAMLProvider
+		roles    []SAMLRole
+		want     SAMLRole
+		wantErr  bool
+	}{
+		{"configured role", AssumeRoleWithSAMLProvider{RoleARN: testSAMLRoles[0].RoleARN}, testSAMLRoles, testSAMLRoles[0], false},
+		{"configured role not in assertion", AssumeRoleWithSAMLProvider{RoleARN: "arn:aws:iam::333333333333:role/other"}, testSAMLRoles, SAMLRole{}, true},
+		{"configured role and principal", AssumeRoleWithSAMLProvider{RoleARN: "arn:aws:iam::333333333333:role/other", PrincipalARN: "arn:aws:iam::333333333333:saml-provider/okta"}, nil, SAMLRole{RoleARN: "arn:aws:iam::333333333333:role/other", PrincipalARN: "arn:aws:iam::333333333333:saml-provider/okta"}, false},
+		{"single role", AssumeRoleWithSAMLProvider{}, testSAMLRoles[:1], testSAMLRoles[0], false},
+		{"no roles", AssumeRoleWithSAMLProvider{}, nil, SAMLRole{}, true},
+		{"prompt for role", AssumeRoleWithSAMLProvider{}, testSAMLRoles, testSAMLRoles[1], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.provider.rolePromptFunc = promptFunc
+			got, err := tt.provider.chooseRole(tt.roles)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("chooseRole() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("chooseRole() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if prompted != testSAMLRoles[1] {
+		t.Fatalf("Expected the user to be prompted")
+	}
+
+	p := AssumeRoleWithSAMLProvider{rolePromptFunc: func([]SAMLRole) (SAMLRole, error) { return SAMLRole{}, errors.New("cancelled") }}
+	if _, err := p.chooseRole(testSAMLRoles); err == nil {
+		t.Fatal("Expected prompt error to be returned")
+	}
+
+	p = AssumeRoleWithSAMLProvider{}
+	if _, err := p.chooseRole(testSAMLRoles); err == nil || !strings.Contains(err.Error(), "set role_arn") {
+		t.Fatalf("Expected an error asking for role_arn without a prompt, got %v", err)
+	}
+}
+
+func TestSAMLResponseFromHTML(t *testing.T) {
+	body := `<html><body><form method="post" action="https://signin.aws.amazon.com/saml">
+<input type="hidden" name="RelayState" value="" />
+<input type="hidden" value="PHNhbWw+&#x2B;PC9zYW1sPg==" name="SAMLResponse" />
+</form></body></html>`
+
+	if got := samlResponseFromHTML(body); got != "PHNhbWw++PC9zYW1sPg==" {
+		t.Fatalf("Unexpected SAMLResponse %q", got)
+	}
+	if got := samlResponseFromHTML("<html></html>"); got != "" {
+		t.Fatalf("Expected no SAMLResponse, got %q", got)
+	}
+}
+
+func TestAssumeRoleWithSAMLSessionKey(t *testing.T) {
+	sessionKey := func(roleARN string) SessionMetadata {
+		p, err := NewAssumeRoleWithSAMLProvider(keyring.NewArrayKeyring(nil), &ProfileConfig{
+			ProfileName:          "adfs",
+			RoleARN:              roleARN,
+			SAMLAssertionProcess: "saml2aws",
+		}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.(*CachedSessionProvider).SessionKey
+	}
+
+	admin := sessionKey("arn:aws:iam::111111111111:role/admin")
+	readonly := sessionKey("arn:aws:iam::222222222222:role/readonly")
+	if admin.MfaSerial != "" {
+		t.Fatalf("Expected no MFA serial in the session key, got %q", admin.MfaSerial)
+	}
+	if admin.Type == readonly.Type || !strings.HasPrefix(admin.Type, "sts.AssumeRoleWithSAML+role-") {
+		t.Fatalf("Expected session types that distinguish the roles, got %q and %q", admin.Type, readonly.Type)
+	}
+
+	p, err := NewAssumeRoleWithSAMLProvider(keyring.NewArrayKeyring(nil), &ProfileConfig{
+		ProfileName:          "adfs",
+		SAMLAssertionProcess: "saml2aws",
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*AssumeRoleWithSAMLProvider); !ok {
+		t.Fatalf("Expected the session not to be cached without role_arn, got %T", p)
+	}
+}
+
+func TestFetchSAMLAssertionFromIdp(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sso" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "idp"})
+			http.Redirect(w, r, "/form", http.StatusFound)
+			return
+		}
+		if c, err := r.Cookie("session"); err != nil || c.Value != "idp" {
+			http.Error(w, "no session", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `<form><input type="hidden" name="SAMLResponse" value="PHNhbWw+PC9zYW1sPg==" /></form>`)
+	}))
+	defer ts.Close()
+
+	requests := 0
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requests++
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+
+	assertion, err := fetchSAMLAssertionFromIdp(context.Background(), client, ts.URL+"/sso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion != "PHNhbWw+PC9zYW1sPg==" {
+		t.Fatalf("Unexpected assertion %q", assertion)
+	}
+	if requests != 2 {
+		t.Fatalf("Expected both requests to use the configured client, got %d", requests)
+	}
+	if client.Jar != nil {
+		t.Fatal("Expected the configured client to be left without a cookie jar")
+	}
+}
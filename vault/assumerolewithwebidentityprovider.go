@@ -21,6 +21,8 @@ type AssumeRoleWithWebIdentityProvider struct {
 	WebIdentityTokenProcess string
 	ExternalID              string
 	Duration                time.Duration
+	PolicyARNs              []string
+	Policy                  string
 }
 
 // Retrieve generates a new set of temporary credentials using STS AssumeRoleWithWebIdentity
@@ -56,12 +58,18 @@ func (p *AssumeRoleWithWebIdentityProvider) RetrieveStsCredentials(ctx context.C
 		return nil, err
 	}
 
-	resp, err := p.StsClient.AssumeRoleWithWebIdentity(ctx, &sts.AssumeRoleWithWebIdentityInput{
+	input := &sts.AssumeRoleWithWebIdentityInput{
 		RoleArn:          aws.String(p.RoleARN),
 		RoleSessionName:  aws.String(p.roleSessionName()),
 		DurationSeconds:  aws.Int32(int32(p.Duration.Seconds())),
 		WebIdentityToken: aws.String(webIdentityToken),
-	})
+		PolicyArns:       policyDescriptors(p.PolicyARNs),
+	}
+	if p.Policy != "" {
+		input.Policy = aws.String(p.Policy)
+	}
+
+	resp, err := p.StsClient.AssumeRoleWithWebIdentity(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -72,16 +80,20 @@ func (p *AssumeRoleWithWebIdentityProvider) RetrieveStsCredentials(ctx context.C
 }
 
 func (p *AssumeRoleWithWebIdentityProvider) webIdentityToken() (string, error) {
-	// Read OpenID Connect token from WebIdentityTokenFile
-	if p.WebIdentityTokenFile != "" {
-		b, err := os.ReadFile(p.WebIdentityTokenFile)
+	return readWebIdentityToken(p.WebIdentityTokenFile, p.WebIdentityTokenProcess)
+}
+
+func readWebIdentityToken(tokenFile, tokenProcess string) (string, error) {
+	// Read OpenID Connect token from tokenFile
+	if tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
 		if err != nil {
-			return "", fmt.Errorf("unable to read file at %s: %v", p.WebIdentityTokenFile, err)
+			return "", fmt.Errorf("unable to read file at %s: %v", tokenFile, err)
 		}
 
 		return string(b), nil
 	}
 
-	// Exec WebIdentityTokenProcess to retrieve OpenID Connect token
-	return executeProcess(p.WebIdentityTokenProcess)
+	// Exec tokenProcess to retrieve OpenID Connect token
+	return executeProcess(tokenProcess)
 }
@@ -0,0 +1,75 @@
+package vault
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+const (
+	// assumeRootMaximumDuration is the longest session AssumeRoot allows
+	assumeRootMaximumDuration = 15 * time.Minute
+
+	rootTaskPolicyARNPrefix = "arn:aws:iam::aws:policy/root-task/"
+)
+
+// AssumeRootProvider retrieves task-scoped root credentials for a member account using STS AssumeRoot
+type AssumeRootProvider struct {
+	StsClient       *sts.Client
+	TargetPrincipal string
+	TaskPolicyARN   string
+	Duration        time.Duration
+}
+
+// Retrieve generates a new set of temporary credentials using STS AssumeRoot
+func (p *AssumeRootProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
+
+func (p *AssumeRootProvider) duration() time.Duration {
+	if p.Duration == 0 || p.Duration > assumeRootMaximumDuration {
+		return assumeRootMaximumDuration
+	}
+	return p.Duration
+}
+
+func (p *AssumeRootProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	resp, err := p.StsClient.AssumeRoot(ctx, &sts.AssumeRootInput{
+		TargetPrincipal: aws.String(p.TargetPrincipal),
+		TaskPolicyArn: &ststypes.PolicyDescriptorType{
+			Arn: aws.String(RootTaskPolicyARN(p.TaskPolicyARN)),
+		},
+		DurationSeconds: aws.Int32(int32(p.duration().Seconds())),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Generated credentials %s using AssumeRoot for %s, expires in %s", FormatKeyForDisplay(*resp.Credentials.AccessKeyId), p.TargetPrincipal, time.Until(*resp.Credentials.Expiration).String())
+
+	return resp.Credentials, nil
+}
+
+// RootTaskPolicyARN expands a root task policy name like IAMAuditRootUserCredentials to its full ARN
+func RootTaskPolicyARN(s string) string {
+	if strings.HasPrefix(s, "arn:") {
+		return s
+	}
+	return rootTaskPolicyARNPrefix + s
+}
@@ -0,0 +1,92 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+func newAssumeRootTestProvider(config *ProfileConfig, handler roundTripFunc) *AssumeRootProvider {
+	cfg := NewAwsConfigWithCredsProvider(credentials.NewStaticCredentialsProvider("AKIAMANAGEMENT", "secret", ""), "us-east-1", config)
+	cfg.HTTPClient = handler
+	return &AssumeRootProvider{
+		StsClient:       sts.NewFromConfig(cfg),
+		TargetPrincipal: "222222222222",
+		TaskPolicyARN:   "S3UnlockBucketPolicy",
+		Duration:        time.Hour,
+	}
+}
+
+func TestAssumeRootProviderRetrieve(t *testing.T) {
+	expiration := time.Now().Add(15 * time.Minute).UTC().Truncate(time.Second)
+
+	p := newAssumeRootTestProvider(&ProfileConfig{UseFIPSEndpoint: aws.FIPSEndpointStateEnabled}, func(r *http.Request) (*http.Response, error) {
+		if r.URL.Host != "sts-fips.us-east-1.amazonaws.com" {
+			t.Errorf("Expected the FIPS regional STS endpoint, got %s", r.URL.Host)
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIAMANAGEMENT/") {
+			t.Errorf("Unexpected Authorization header %q", r.Header.Get("Authorization"))
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if form.Get("Action") != "AssumeRoot" ||
+			form.Get("TargetPrincipal") != "222222222222" ||
+			form.Get("TaskPolicyArn.arn") != "arn:aws:iam::aws:policy/root-task/S3UnlockBucketPolicy" ||
+			form.Get("DurationSeconds") != "900" {
+			t.Errorf("Unexpected AssumeRoot request %v", form)
+		}
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body: io.NopCloser(strings.NewReader(fmt.Sprintf(`<AssumeRootResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRootResult>
+    <Credentials>
+      <AccessKeyId>ASIAROOTEXAMPLE1</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRootResult>
+</AssumeRootResponse>`, expiration.Format(time.RFC3339)))),
+		}, nil
+	})
+
+	creds, err := p.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIAROOTEXAMPLE1" || !creds.Expires.Equal(expiration) {
+		t.Fatalf("Unexpected credentials %v", creds)
+	}
+}
+
+func TestAssumeRootProviderError(t *testing.T) {
+	p := newAssumeRootTestProvider(&ProfileConfig{MaxAttempts: 1}, func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`<ErrorResponse><Error><Code>AccessDenied</Code><Message>not allowed</Message></Error></ErrorResponse>`)),
+		}, nil
+	})
+
+	_, err := p.Retrieve(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied: not allowed") {
+		t.Fatalf("Expected AccessDenied error, got %v", err)
+	}
+}
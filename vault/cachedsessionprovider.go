@@ -2,7 +2,9 @@ package vault
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -21,15 +23,30 @@ type CachedSessionProvider struct {
 	SessionProvider StsSessionProvider
 	Keyring         *SessionKeyring
 	ExpiryWindow    time.Duration
+
+	// OfflineGrace returns the cached session while it's still valid when it can't be refreshed because
+	// the service is unavailable, rather than failing
+	OfflineGrace bool
 }
 
 func (p *CachedSessionProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
 	creds, err := p.Keyring.Get(p.SessionKey)
 
 	if err != nil || time.Until(*creds.Expiration) < p.ExpiryWindow {
+		cached := creds
+		if err != nil {
+			cached = nil
+		}
+
 		// lookup missed, we need to create a new one.
 		creds, err = p.SessionProvider.RetrieveStsCredentials(ctx)
 		if err != nil {
+			if p.OfflineGrace && cached != nil && time.Now().Before(*cached.Expiration) && isServiceUnavailable(ctx, err) {
+				log.Printf("Couldn't refresh %s: %s", p.SessionKey.Type, err.Error())
+				fmt.Fprintf(os.Stderr, "aws-vault: warning: can't reach AWS to refresh the session for %s, using the cached session which expires in %s\n",
+					p.SessionKey.ProfileName, time.Until(*cached.Expiration).Truncate(time.Second))
+				return cached, nil
+			}
 			return nil, err
 		}
 		err = p.Keyring.Set(p.SessionKey, creds)
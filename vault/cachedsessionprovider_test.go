@@ -0,0 +1,79 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+type failingSessionProvider struct {
+	err error
+}
+
+func (p failingSessionProvider) Retrieve(context.Context) (aws.Credentials, error) {
+	return aws.Credentials{}, p.err
+}
+
+func (p failingSessionProvider) RetrieveStsCredentials(context.Context) (*ststypes.Credentials, error) {
+	return nil, p.err
+}
+
+func newCachedSessionProviderWithSession(t *testing.T, expiresIn time.Duration, err error) *CachedSessionProvider {
+	t.Helper()
+
+	p := &CachedSessionProvider{
+		SessionKey:      SessionMetadata{Type: "sts.GetSessionToken", ProfileName: "jonsmith"},
+		SessionProvider: failingSessionProvider{err: err},
+		Keyring:         &SessionKeyring{Keyring: keyring.NewArrayKeyring(nil)},
+		ExpiryWindow:    5 * time.Minute,
+		OfflineGrace:    true,
+	}
+	err = p.Keyring.Set(p.SessionKey, &ststypes.Credentials{
+		AccessKeyId:     aws.String("ASIACACHED"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(expiresIn)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCachedSessionProviderOfflineGrace(t *testing.T) {
+	unavailable := &smithyhttp.RequestSendError{Err: errors.New("no such host")}
+
+	p := newCachedSessionProviderWithSession(t, 2*time.Minute, unavailable)
+	creds, err := p.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Expected the cached session, got %v", err)
+	}
+	if creds.AccessKeyID != "ASIACACHED" {
+		t.Fatalf("Expected the cached session, got %s", creds.AccessKeyID)
+	}
+
+	p.OfflineGrace = false
+	if _, err = p.Retrieve(context.Background()); err == nil {
+		t.Fatal("Expected an error without offline grace")
+	}
+}
+
+func TestCachedSessionProviderOfflineGraceNeedsUnavailableService(t *testing.T) {
+	p := newCachedSessionProviderWithSession(t, 2*time.Minute, errors.New("invalid MFA one time pass code"))
+	if _, err := p.Retrieve(context.Background()); err == nil {
+		t.Fatal("Expected the error when the refresh was rejected")
+	}
+}
+
+func TestCachedSessionProviderOfflineGraceNeedsValidSession(t *testing.T) {
+	p := newCachedSessionProviderWithSession(t, -time.Minute, &smithyhttp.RequestSendError{Err: errors.New("no such host")})
+	if _, err := p.Retrieve(context.Background()); err == nil {
+		t.Fatal("Expected an error when the cached session has expired")
+	}
+}
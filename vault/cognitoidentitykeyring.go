@@ -0,0 +1,70 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/99designs/keyring"
+)
+
+// CognitoIdentityKeyring stores the Cognito identity IDs returned by GetId, so
+// that refreshing credentials doesn't need to look the identity up again
+type CognitoIdentityKeyring struct {
+	Keyring keyring.Keyring
+}
+
+const cognitoIdentityKeyPrefix = "cognito-identity:"
+
+// fmtKey returns the key for an identity, which depends on both the pool and the login provider
+func (c *CognitoIdentityKeyring) fmtKey(poolID, loginProvider string) string {
+	return cognitoIdentityKeyPrefix + poolID + "," + loginProvider
+}
+
+func IsCognitoIdentityKey(k string) bool {
+	return strings.HasPrefix(k, cognitoIdentityKeyPrefix)
+}
+
+func (c CognitoIdentityKeyring) Get(poolID, loginProvider string) (string, error) {
+	item, err := c.Keyring.Get(c.fmtKey(poolID, loginProvider))
+	if err != nil {
+		return "", err
+	}
+	return string(item.Data), nil
+}
+
+func (c CognitoIdentityKeyring) Set(poolID, loginProvider, identityID string) error {
+	return c.Keyring.Set(keyring.Item{
+		Key:         c.fmtKey(poolID, loginProvider),
+		Data:        []byte(identityID),
+		Label:       fmt.Sprintf("aws-vault cognito identity for %s", poolID),
+		Description: "aws-vault cognito identity",
+	})
+}
+
+func (c CognitoIdentityKeyring) Remove(poolID, loginProvider string) error {
+	return c.Keyring.Remove(c.fmtKey(poolID, loginProvider))
+}
+
+func (c *CognitoIdentityKeyring) RemoveForPool(poolID string) (n int, err error) {
+	return c.removeWithPrefix(cognitoIdentityKeyPrefix + poolID + ",")
+}
+
+func (c *CognitoIdentityKeyring) RemoveAll() (n int, err error) {
+	return c.removeWithPrefix(cognitoIdentityKeyPrefix)
+}
+
+func (c *CognitoIdentityKeyring) removeWithPrefix(prefix string) (n int, err error) {
+	allKeys, err := c.Keyring.Keys()
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range allKeys {
+		if strings.HasPrefix(k, prefix) {
+			if err = c.Keyring.Remove(k); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,149 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentity"
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentity/types"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+type CognitoIdentityCacher interface {
+	Get(poolID, loginProvider string) (string, error)
+	Set(poolID, loginProvider, identityID string) error
+	Remove(poolID, loginProvider string) error
+}
+
+// CognitoIdentityProvider retrieves temporary credentials from an Amazon Cognito identity pool
+type CognitoIdentityProvider struct {
+	CognitoClient           *cognitoidentity.Client
+	IdentityPoolID          string
+	LoginProvider           string
+	WebIdentityTokenFile    string
+	WebIdentityTokenProcess string
+	CustomRoleARN           string
+	IdentityCache           CognitoIdentityCacher
+}
+
+// Retrieve generates a new set of temporary credentials using Cognito GetCredentialsForIdentity
+func (p *CognitoIdentityProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
+
+// logins returns the Logins map for the identity, which is empty for unauthenticated identities
+func (p *CognitoIdentityProvider) logins() (map[string]string, error) {
+	if p.LoginProvider == "" {
+		return nil, nil
+	}
+
+	token, err := readWebIdentityToken(p.WebIdentityTokenFile, p.WebIdentityTokenProcess)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{p.LoginProvider: strings.TrimSpace(token)}, nil
+}
+
+func (p *CognitoIdentityProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	logins, err := p.logins()
+	if err != nil {
+		return nil, err
+	}
+
+	identityID, cached, err := p.getIdentityID(ctx, logins)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &cognitoidentity.GetCredentialsForIdentityInput{
+		IdentityId: aws.String(identityID),
+		Logins:     logins,
+	}
+	if p.CustomRoleARN != "" {
+		input.CustomRoleArn = aws.String(p.CustomRoleARN)
+	}
+
+	resp, err := p.CognitoClient.GetCredentialsForIdentity(ctx, input)
+	if err != nil {
+		// A cached identity may have been deleted from the pool, or may belong
+		// to a different user than the current token. Remove it and look the
+		// identity up again, which only happens once as the cache is cleared.
+		var notFound *cognitotypes.ResourceNotFoundException
+		var notAuthorized *cognitotypes.NotAuthorizedException
+		if cached && (errors.As(err, &notFound) || errors.As(err, &notAuthorized)) {
+			log.Printf("Cached Cognito identity %s was rejected, looking it up again", identityID)
+			if err = p.IdentityCache.Remove(p.IdentityPoolID, p.LoginProvider); err != nil {
+				return nil, err
+			}
+			return p.RetrieveStsCredentials(ctx)
+		}
+		return nil, err
+	}
+	if resp.Credentials == nil {
+		return nil, errors.New("Cognito GetCredentialsForIdentity returned no credentials")
+	}
+
+	log.Printf("Generated credentials %s using Cognito identity %s, expires in %s", FormatKeyForDisplay(*resp.Credentials.AccessKeyId), identityID, time.Until(*resp.Credentials.Expiration).String())
+
+	return &ststypes.Credentials{
+		AccessKeyId:     resp.Credentials.AccessKeyId,
+		SecretAccessKey: resp.Credentials.SecretKey,
+		SessionToken:    resp.Credentials.SessionToken,
+		Expiration:      resp.Credentials.Expiration,
+	}, nil
+}
+
+// getIdentityID returns the identity ID from the cache, or from GetId if it isn't cached
+func (p *CognitoIdentityProvider) getIdentityID(ctx context.Context, logins map[string]string) (identityID string, cached bool, err error) {
+	if p.IdentityCache != nil {
+		identityID, err = p.IdentityCache.Get(p.IdentityPoolID, p.LoginProvider)
+		if err != nil && err != keyring.ErrKeyNotFound {
+			return "", false, err
+		}
+		if identityID != "" {
+			return identityID, true, nil
+		}
+	}
+
+	id, err := p.CognitoClient.GetId(ctx, &cognitoidentity.GetIdInput{
+		IdentityPoolId: aws.String(p.IdentityPoolID),
+		Logins:         logins,
+	})
+	if err != nil {
+		return "", false, err
+	}
+	identityID = aws.ToString(id.IdentityId)
+
+	if p.IdentityCache != nil {
+		if err = p.IdentityCache.Set(p.IdentityPoolID, p.LoginProvider, identityID); err != nil {
+			return "", false, err
+		}
+	}
+
+	return identityID, false, nil
+}
+
+// cognitoIdentityPoolRegion returns the region from an identity pool ID like us-east-1:00000000-0000-0000-0000-000000000000
+func cognitoIdentityPoolRegion(poolID string) string {
+	if i := strings.Index(poolID, ":"); i > 0 {
+		return poolID[:i]
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentity"
+)
+
+func TestCognitoIdentityProviderCachesIdentityID(t *testing.T) {
+	var getIDCalls int
+	validIdentityID := "us-east-1:identity-1"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "AWSCognitoIdentityService.GetId":
+			getIDCalls++
+			fmt.Fprintf(w, `{"IdentityId":%q}`, validIdentityID)
+		case "AWSCognitoIdentityService.GetCredentialsForIdentity":
+			if body["IdentityId"] != validIdentityID {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type":"NotAuthorizedException","message":"Invalid identity"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"IdentityId":%q,"Credentials":{"AccessKeyId":"ASIACOGNITO","SecretKey":"secret","SessionToken":"token","Expiration":%d}}`,
+				validIdentityID, time.Now().Add(time.Hour).Unix())
+		default:
+			t.Errorf("Unexpected request %s", r.Header.Get("X-Amz-Target"))
+		}
+	}))
+	defer ts.Close()
+
+	cache := CognitoIdentityKeyring{Keyring: keyring.NewArrayKeyring(nil)}
+	p := &CognitoIdentityProvider{
+		CognitoClient: cognitoidentity.New(cognitoidentity.Options{
+			Region:           "us-east-1",
+			EndpointResolver: cognitoidentity.EndpointResolverFromURL(ts.URL),
+			Credentials:      aws.AnonymousCredentials{},
+		}),
+		IdentityPoolID: "us-east-1:pool",
+		IdentityCache:  cache,
+	}
+
+	for i := 0; i < 2; i++ {
+		creds, err := p.RetrieveStsCredentials(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if aws.ToString(creds.AccessKeyId) != "ASIACOGNITO" {
+			t.Fatalf("Unexpected credentials %v", creds)
+		}
+	}
+	if getIDCalls != 1 {
+		t.Fatalf("Expected GetId to be called once, got %d", getIDCalls)
+	}
+
+	// A rejected identity is looked up again
+	validIdentityID = "us-east-1:identity-2"
+	if _, err := p.RetrieveStsCredentials(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if getIDCalls != 2 {
+		t.Fatalf("Expected GetId to be called again, got %d calls", getIDCalls)
+	}
+	if id, _ := cache.Get("us-east-1:pool", ""); id != validIdentityID {
+		t.Fatalf("Expected the new identity to be cached, got %q", id)
+	}
+}
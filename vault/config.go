@@ -6,9 +6,11 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	ini "gopkg.in/ini.v1"
 )
 
@@ -19,6 +21,9 @@ const (
 	// DefaultChainedSessionDuration is the default duration for GetSessionToken sessions when chaining
 	DefaultChainedSessionDuration = time.Hour * 8
 
+	// DefaultMinTTL is the default time left before cached sessions expire at which they're refreshed
+	DefaultMinTTL = 5 * time.Minute
+
 	defaultSectionName          = "default"
 	roleChainingMaximumDuration = 1 * time.Hour
 )
@@ -146,6 +151,37 @@ type ProfileSection struct {
 	SourceIdentity          string `ini:"source_identity,omitempty"`
 	CredentialProcess       string `ini:"credential_process,omitempty"`
 	MfaProcess              string `ini:"mfa_process,omitempty"`
+	SAMLAssertionProcess    string `ini:"saml_assertion_process,omitempty"`
+	SAMLIdpURL              string `ini:"saml_idp_url,omitempty"`
+	PrincipalARN            string `ini:"principal_arn,omitempty"`
+	TrustAnchorARN          string `ini:"trust_anchor_arn,omitempty"`
+	ProfileARN              string `ini:"profile_arn,omitempty"`
+	RolesAnywhereCert       string `ini:"rolesanywhere_certificate,omitempty"`
+	RolesAnywherePrivateKey string `ini:"rolesanywhere_private_key,omitempty"`
+	RolesAnywherePKCS12     string `ini:"rolesanywhere_pkcs12,omitempty"`
+	RolesAnywherePKCS11Lib  string `ini:"rolesanywhere_pkcs11_lib,omitempty"`
+	CognitoIdentityPoolID   string `ini:"cognito_identity_pool_id,omitempty"`
+	CognitoLoginProvider    string `ini:"cognito_login_provider,omitempty"`
+	TargetPrincipal         string `ini:"target_principal,omitempty"`
+	TaskPolicyARN           string `ini:"task_policy_arn,omitempty"`
+	PolicyARNs              string `ini:"policy_arns,omitempty"`
+	Policy                  string `ini:"policy,omitempty"`
+	PolicyFile              string `ini:"policy_file,omitempty"`
+	EcsAllowedRoleARNs      string `ini:"ecs_allowed_role_arns,omitempty"`
+	AwsVaultEnv             string `ini:"aws_vault_env,omitempty"`
+	EndpointURL             string `ini:"endpoint_url,omitempty"`
+	Services                string `ini:"services,omitempty"`
+	STSEndpointURL          string `ini:"sts_endpoint_url,omitempty"`
+	UseFIPSEndpoint         string `ini:"use_fips_endpoint,omitempty"`
+	UseDualStackEndpoint    string `ini:"use_dualstack_endpoint,omitempty"`
+	CABundle                string `ini:"ca_bundle,omitempty"`
+	ProxyURL                string `ini:"proxy_url,omitempty"`
+	RetryMode               string `ini:"retry_mode,omitempty"`
+	MaxAttempts             int    `ini:"max_attempts,omitempty"`
+	RequestTimeout          string `ini:"aws_vault_request_timeout,omitempty"`
+	STSFailoverRegions      string `ini:"sts_failover_regions,omitempty"`
+	OfflineGrace            bool   `ini:"aws_vault_offline_grace,omitempty"`
+	MinTTL                  string `ini:"aws_vault_min_ttl,omitempty"`
 }
 
 // SSOSessionSection is a [sso-session] section of the config file
@@ -178,7 +214,7 @@ func (c *ConfigFile) ProfileSections() []ProfileSection {
 			}
 
 			result = append(result, profile)
-		} else if strings.HasPrefix(section, "sso-session ") {
+		} else if strings.HasPrefix(section, "sso-session ") || strings.HasPrefix(section, "services ") {
 			// Not a profile
 			continue
 		} else {
@@ -211,9 +247,35 @@ func (c *ConfigFile) ProfileSection(name string) (ProfileSection, bool) {
 	if err = section.MapTo(&profile); err != nil {
 		panic(err)
 	}
+	// aws_vault_env has a variable on each nested line
+	if key, err := section.GetKey("aws_vault_env"); err == nil && len(key.NestedValues()) > 0 {
+		profile.AwsVaultEnv = strings.Join(key.NestedValues(), "\n")
+	}
 	return profile, true
 }
 
+// ServiceEndpointURLs returns the endpoint_url of each service in the [services] section with the matching name,
+// keyed by the service's key in the section. The bool is false if there isn't any section with the name.
+func (c *ConfigFile) ServiceEndpointURLs(name string) (map[string]string, bool) {
+	if c.iniFile == nil {
+		return nil, false
+	}
+	section, err := c.iniFile.GetSection("services " + name)
+	if err != nil {
+		return nil, false
+	}
+
+	endpoints := map[string]string{}
+	for _, key := range section.Keys() {
+		for _, v := range key.NestedValues() {
+			if k, url, ok := strings.Cut(v, "="); ok && strings.TrimSpace(k) == "endpoint_url" {
+				endpoints[key.Name()] = strings.TrimSpace(url)
+			}
+		}
+	}
+	return endpoints, true
+}
+
 // SSOSessionSection returns the [sso-session] section with the matching name. If there isn't any,
 // an empty sso-session with the provided name is returned, along with false.
 func (c *ConfigFile) SSOSessionSection(name string) (SSOSessionSection, bool) {
@@ -390,6 +452,130 @@ func (cl *ConfigLoader) populateFromConfigFile(config *ProfileConfig, profileNam
 	if config.MfaProcess == "" {
 		config.MfaProcess = psection.MfaProcess
 	}
+	if config.SAMLAssertionProcess == "" {
+		config.SAMLAssertionProcess = psection.SAMLAssertionProcess
+	}
+	if config.SAMLIdpURL == "" {
+		config.SAMLIdpURL = psection.SAMLIdpURL
+	}
+	if config.PrincipalARN == "" {
+		config.PrincipalARN = psection.PrincipalARN
+	}
+	if config.TrustAnchorARN == "" {
+		config.TrustAnchorARN = psection.TrustAnchorARN
+	}
+	if config.ProfileARN == "" {
+		config.ProfileARN = psection.ProfileARN
+	}
+	if config.RolesAnywhereCert == "" {
+		config.RolesAnywhereCert = psection.RolesAnywhereCert
+	}
+	if config.RolesAnywherePrivateKey == "" {
+		config.RolesAnywherePrivateKey = psection.RolesAnywherePrivateKey
+	}
+	if config.RolesAnywherePKCS12 == "" {
+		config.RolesAnywherePKCS12 = psection.RolesAnywherePKCS12
+	}
+	if config.RolesAnywherePKCS11Lib == "" {
+		config.RolesAnywherePKCS11Lib = psection.RolesAnywherePKCS11Lib
+	}
+	if config.CognitoIdentityPoolID == "" {
+		config.CognitoIdentityPoolID = psection.CognitoIdentityPoolID
+	}
+	if config.CognitoLoginProvider == "" {
+		config.CognitoLoginProvider = psection.CognitoLoginProvider
+	}
+	if config.TargetPrincipal == "" {
+		config.TargetPrincipal = psection.TargetPrincipal
+	}
+	if config.TaskPolicyARN == "" {
+		config.TaskPolicyARN = psection.TaskPolicyARN
+	}
+	if policyARNs := psection.PolicyARNs; policyARNs != "" && config.PolicyARNs == nil {
+		config.SetPolicyARNs(policyARNs)
+	}
+	if allowedRoleARNs := psection.EcsAllowedRoleARNs; allowedRoleARNs != "" && config.EcsAllowedRoleARNs == nil {
+		config.EcsAllowedRoleARNs = splitCommaSeparated(allowedRoleARNs)
+	}
+	if env := psection.AwsVaultEnv; env != "" {
+		if err := config.mergeEnv(env); err != nil {
+			return fmt.Errorf("Failed to parse aws_vault_env profile setting: %w", err)
+		}
+	}
+	if config.EndpointURL == "" {
+		config.EndpointURL = psection.EndpointURL
+	}
+	if config.STSEndpointURL == "" {
+		config.STSEndpointURL = psection.STSEndpointURL
+	}
+	if config.UseFIPSEndpoint == aws.FIPSEndpointStateUnset {
+		state, err := parseFIPSEndpointState(psection.UseFIPSEndpoint)
+		if err != nil {
+			return fmt.Errorf("Failed to parse use_fips_endpoint profile setting: %w", err)
+		}
+		config.UseFIPSEndpoint = state
+	}
+	if config.UseDualStackEndpoint == aws.DualStackEndpointStateUnset {
+		state, err := parseDualStackEndpointState(psection.UseDualStackEndpoint)
+		if err != nil {
+			return fmt.Errorf("Failed to parse use_dualstack_endpoint profile setting: %w", err)
+		}
+		config.UseDualStackEndpoint = state
+	}
+	if config.CABundle == "" && psection.CABundle != "" {
+		if _, err := loadCABundle(psection.CABundle); err != nil {
+			return fmt.Errorf("Failed to load ca_bundle profile setting: %w", err)
+		}
+		config.CABundle = psection.CABundle
+	}
+	if config.ProxyURL == "" && psection.ProxyURL != "" {
+		if _, err := parseProxyURL(psection.ProxyURL); err != nil {
+			return fmt.Errorf("Failed to parse proxy_url profile setting: %w", err)
+		}
+		config.ProxyURL = psection.ProxyURL
+	}
+	if config.RetryMode == "" && psection.RetryMode != "" {
+		mode, err := aws.ParseRetryMode(psection.RetryMode)
+		if err != nil {
+			return fmt.Errorf("Failed to parse retry_mode profile setting: %w", err)
+		}
+		config.RetryMode = mode
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = psection.MaxAttempts
+	}
+	if config.RequestTimeout == 0 && psection.RequestTimeout != "" {
+		timeout, err := time.ParseDuration(psection.RequestTimeout)
+		if err != nil {
+			return fmt.Errorf("Failed to parse aws_vault_request_timeout profile setting: %w", err)
+		}
+		config.RequestTimeout = timeout
+	}
+	if regions := psection.STSFailoverRegions; regions != "" && config.STSFailoverRegions == nil {
+		config.STSFailoverRegions = splitCommaSeparated(regions)
+	}
+	if !config.OfflineGrace {
+		config.OfflineGrace = psection.OfflineGrace
+	}
+	if config.MinTTL == 0 && psection.MinTTL != "" {
+		minTTL, err := time.ParseDuration(psection.MinTTL)
+		if err != nil {
+			return fmt.Errorf("Failed to parse aws_vault_min_ttl profile setting: %w", err)
+		}
+		config.MinTTL = minTTL
+	}
+	if psection.Services != "" && config.ServiceEndpointURLs == nil {
+		endpoints, ok := cl.File.ServiceEndpointURLs(psection.Services)
+		if ok {
+			config.ServiceEndpointURLs = endpoints
+		} else {
+			log.Printf("[services] '%s' missing in config file", psection.Services)
+		}
+	}
+	if config.Policy == "" && config.PolicyFile == "" {
+		config.Policy = psection.Policy
+		config.PolicyFile = psection.PolicyFile
+	}
 	if sessionTags := psection.SessionTags; sessionTags != "" && config.SessionTags == nil {
 		err := config.SetSessionTags(sessionTags)
 		if err != nil {
@@ -436,6 +622,46 @@ func (cl *ConfigLoader) populateFromEnv(profile *ProfileConfig) {
 		profile.STSRegionalEndpoints = stsRegionalEndpoints
 	}
 
+	if useFIPS := os.Getenv("AWS_USE_FIPS_ENDPOINT"); useFIPS != "" && profile.UseFIPSEndpoint == aws.FIPSEndpointStateUnset {
+		if state, err := parseFIPSEndpointState(useFIPS); err == nil {
+			log.Printf("Using use_fips_endpoint %q from AWS_USE_FIPS_ENDPOINT", useFIPS)
+			profile.UseFIPSEndpoint = state
+		}
+	}
+
+	if useDualStack := os.Getenv("AWS_USE_DUALSTACK_ENDPOINT"); useDualStack != "" && profile.UseDualStackEndpoint == aws.DualStackEndpointStateUnset {
+		if state, err := parseDualStackEndpointState(useDualStack); err == nil {
+			log.Printf("Using use_dualstack_endpoint %q from AWS_USE_DUALSTACK_ENDPOINT", useDualStack)
+			profile.UseDualStackEndpoint = state
+		}
+	}
+
+	if caBundle := os.Getenv("AWS_CA_BUNDLE"); caBundle != "" && profile.CABundle == "" {
+		log.Printf("Using ca_bundle %q from AWS_CA_BUNDLE", caBundle)
+		profile.CABundle = caBundle
+	}
+
+	if retryMode := os.Getenv("AWS_RETRY_MODE"); retryMode != "" && profile.RetryMode == "" {
+		if mode, err := aws.ParseRetryMode(retryMode); err == nil {
+			log.Printf("Using retry_mode %q from AWS_RETRY_MODE", retryMode)
+			profile.RetryMode = mode
+		}
+	}
+
+	if maxAttempts := os.Getenv("AWS_MAX_ATTEMPTS"); maxAttempts != "" && profile.MaxAttempts == 0 {
+		if n, err := strconv.Atoi(maxAttempts); err == nil {
+			log.Printf("Using max_attempts %d from AWS_MAX_ATTEMPTS", n)
+			profile.MaxAttempts = n
+		}
+	}
+
+	if offlineGrace := os.Getenv("AWS_VAULT_OFFLINE_GRACE"); offlineGrace != "" && !profile.OfflineGrace {
+		if enabled, err := strconv.ParseBool(offlineGrace); err == nil && enabled {
+			log.Printf("Using aws_vault_offline_grace from AWS_VAULT_OFFLINE_GRACE")
+			profile.OfflineGrace = true
+		}
+	}
+
 	if mfaSerial := os.Getenv("AWS_MFA_SERIAL"); mfaSerial != "" && profile.MfaSerial == "" {
 		log.Printf("Using mfa_serial %q from AWS_MFA_SERIAL", mfaSerial)
 		profile.MfaSerial = mfaSerial
@@ -463,6 +689,13 @@ func (cl *ConfigLoader) populateFromEnv(profile *ProfileConfig) {
 		}
 	}
 
+	if minTTL := os.Getenv("AWS_MIN_TTL"); minTTL != "" && profile.MinTTL == 0 {
+		profile.MinTTL, err = time.ParseDuration(minTTL)
+		if err == nil {
+			log.Printf("Using a min TTL of %q from AWS_MIN_TTL", profile.MinTTL)
+		}
+	}
+
 	if federationTokenTTL := os.Getenv("AWS_FEDERATION_TOKEN_TTL"); federationTokenTTL != "" && profile.GetFederationTokenDuration == 0 {
 		profile.GetFederationTokenDuration, err = time.ParseDuration(federationTokenTTL)
 		if err == nil {
@@ -518,6 +751,13 @@ func (cl *ConfigLoader) hydrateSourceConfig(config *ProfileConfig) error {
 func (cl *ConfigLoader) GetProfileConfig(profileName string) (*ProfileConfig, error) {
 	config := cl.BaseConfig
 	config.ProfileName = profileName
+
+	// Session policies given on the command line only apply to the target profile
+	if profileName != cl.ActiveProfile {
+		config.PolicyARNs = nil
+		config.Policy = ""
+		config.PolicyFile = ""
+	}
 	cl.populateFromEnv(&config)
 
 	cl.resetLoopDetection()
@@ -528,11 +768,25 @@ func (cl *ConfigLoader) GetProfileConfig(profileName string) (*ProfileConfig, er
 
 	cl.populateFromDefaults(&config)
 
+	err = config.loadPolicyFile()
+	if err != nil {
+		return nil, err
+	}
+
 	err = cl.hydrateSourceConfig(&config)
 	if err != nil {
 		return nil, err
 	}
 
+	// Source profiles are validated with the profile, once it's known they're chained
+	if profileName == cl.ActiveProfile {
+		for c := &config; c != nil; c = c.SourceProfile {
+			if err = c.validateMinTTL(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return &config, nil
 }
 
@@ -573,6 +827,39 @@ type ProfileConfig struct {
 	WebIdentityTokenFile    string
 	WebIdentityTokenProcess string
 
+	// AssumeRoleWithSAML config
+	SAMLAssertionProcess string
+	SAMLIdpURL           string
+	PrincipalARN         string
+
+	// IAM Roles Anywhere config
+	TrustAnchorARN          string
+	ProfileARN              string
+	RolesAnywhereCert       string
+	RolesAnywherePrivateKey string
+	RolesAnywherePKCS12     string
+	RolesAnywherePKCS11Lib  string
+
+	// Cognito identity pool config
+	CognitoIdentityPoolID string
+	CognitoLoginProvider  string
+
+	// AssumeRoot config
+	TargetPrincipal string
+	TaskPolicyARN   string
+
+	// PolicyARNs specifies managed policies to use as session policies
+	PolicyARNs []string
+
+	// Policy specifies an inline session policy in JSON
+	Policy string
+
+	// PolicyFile specifies a file containing an inline session policy in JSON
+	PolicyFile string
+
+	// EcsAllowedRoleARNs are patterns of the role ARNs the ECS server's /role-arn/ route may assume
+	EcsAllowedRoleARNs []string
+
 	// GetSessionTokenDuration specifies the wanted duration for credentials generated with AssumeRole
 	AssumeRoleDuration time.Duration
 
@@ -617,6 +904,48 @@ type ProfileConfig struct {
 
 	// CredentialProcess specifies external command to run to get an AWS credential
 	CredentialProcess string
+
+	// Env specifies extra environment variables for commands run with the profile's credentials
+	Env map[string]string
+
+	// EndpointURL specifies the endpoint for all AWS services
+	EndpointURL string
+
+	// ServiceEndpointURLs specifies endpoints for individual AWS services, keyed by the service's key in [services]
+	ServiceEndpointURLs map[string]string
+
+	// STSEndpointURL specifies the endpoint for STS, overriding EndpointURL and ServiceEndpointURLs
+	STSEndpointURL string
+
+	// UseFIPSEndpoint specifies whether to use FIPS endpoints
+	UseFIPSEndpoint aws.FIPSEndpointState
+
+	// UseDualStackEndpoint specifies whether to use dual-stack endpoints
+	UseDualStackEndpoint aws.DualStackEndpointState
+
+	// CABundle specifies a file of certificates to trust in addition to the system's
+	CABundle string
+
+	// ProxyURL specifies the HTTP proxy for requests to AWS, instead of HTTPS_PROXY
+	ProxyURL string
+
+	// RetryMode specifies how requests to AWS are retried
+	RetryMode aws.RetryMode
+
+	// MaxAttempts specifies the maximum number of attempts for each request to AWS
+	MaxAttempts int
+
+	// RequestTimeout specifies how long each request to AWS may take, including retries
+	RequestTimeout time.Duration
+
+	// STSFailoverRegions specifies the regions to try in turn when the STS endpoint in Region can't be reached
+	STSFailoverRegions []string
+
+	// OfflineGrace specifies that cached sessions are used until they expire when they can't be refreshed
+	OfflineGrace bool
+
+	// MinTTL specifies the time left before cached sessions expire at which they're refreshed
+	MinTTL time.Duration
 }
 
 // SetSessionTags parses a comma separated key=vaue string and sets Config.SessionTags map
@@ -633,6 +962,27 @@ func (c *ProfileConfig) SetSessionTags(s string) error {
 	return nil
 }
 
+// mergeEnv parses KEY=VALUE lines and adds them to Config.Env, keeping variables that are already set
+func (c *ProfileConfig) mergeEnv(s string) error {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return fmt.Errorf("'%s' must be KEY=VALUE", line)
+		}
+		if c.Env == nil {
+			c.Env = map[string]string{}
+		}
+		if _, exists := c.Env[key]; !exists {
+			c.Env[key] = strings.TrimSpace(value)
+		}
+	}
+	return nil
+}
+
 // SetTransitiveSessionTags parses a comma separated string and sets Config.TransitiveSessionTags
 func (c *ProfileConfig) SetTransitiveSessionTags(s string) {
 	for _, tag := range strings.Split(s, ",") {
@@ -642,6 +992,36 @@ func (c *ProfileConfig) SetTransitiveSessionTags(s string) {
 	}
 }
 
+// SetPolicyARNs parses a comma separated string and sets Config.PolicyARNs
+func (c *ProfileConfig) SetPolicyARNs(s string) {
+	c.PolicyARNs = append(c.PolicyARNs, splitCommaSeparated(s)...)
+}
+
+func splitCommaSeparated(s string) []string {
+	values := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// loadPolicyFile reads Config.PolicyFile into Config.Policy
+func (c *ProfileConfig) loadPolicyFile() error {
+	if c.PolicyFile == "" || c.Policy != "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(c.PolicyFile)
+	if err != nil {
+		return fmt.Errorf("Failed to read policy_file: %w", err)
+	}
+	c.Policy = string(b)
+
+	return nil
+}
+
 func (c *ProfileConfig) IsChained() bool {
 	return c.ChainedFromProfile != nil
 }
@@ -658,6 +1038,19 @@ func (c *ProfileConfig) HasRole() bool {
 	return c.RoleARN != ""
 }
 
+func (c *ProfileConfig) HasAssumeRoot() bool {
+	return c.TargetPrincipal != ""
+}
+
+func (c *ProfileConfig) HasSessionPolicies() bool {
+	return len(c.PolicyARNs) > 0 || c.Policy != ""
+}
+
+// CanUseSessionPolicies returns true if credentials for the profile come from an STS call that accepts session policies
+func (c *ProfileConfig) CanUseSessionPolicies() bool {
+	return (c.HasRole() || c.HasSAML()) && !c.HasAssumeRoot() && !c.HasRolesAnywhere() && !c.HasCognitoIdentityPool()
+}
+
 func (c *ProfileConfig) HasSSOSession() bool {
 	return c.SSOSession != ""
 }
@@ -670,10 +1063,58 @@ func (c *ProfileConfig) HasWebIdentity() bool {
 	return c.WebIdentityTokenFile != "" || c.WebIdentityTokenProcess != ""
 }
 
+func (c *ProfileConfig) HasSAML() bool {
+	return c.SAMLAssertionProcess != "" || c.SAMLIdpURL != ""
+}
+
+func (c *ProfileConfig) HasRolesAnywhere() bool {
+	return c.TrustAnchorARN != ""
+}
+
+func (c *ProfileConfig) HasCognitoIdentityPool() bool {
+	return c.CognitoIdentityPoolID != ""
+}
+
 func (c *ProfileConfig) HasCredentialProcess() bool {
 	return c.CredentialProcess != ""
 }
 
+// ExpiryWindow returns the time left before cached sessions expire at which they're refreshed
+func (c *ProfileConfig) ExpiryWindow() time.Duration {
+	if c.MinTTL == 0 {
+		return DefaultMinTTL
+	}
+	return c.MinTTL
+}
+
+// cachedSessionDuration returns the duration of the sessions cached for the profile, or 0 if it's
+// decided by the credential source
+func (c *ProfileConfig) cachedSessionDuration() time.Duration {
+	switch {
+	case c.HasAssumeRoot():
+		if c.AssumeRoleDuration == 0 || c.AssumeRoleDuration > assumeRootMaximumDuration {
+			return assumeRootMaximumDuration
+		}
+		return c.AssumeRoleDuration
+	case c.HasRole() || c.HasRolesAnywhere():
+		return c.AssumeRoleDuration
+	case c.HasSSOSession() || c.HasSSOStartURL() || c.HasCognitoIdentityPool() || c.HasCredentialProcess():
+		return 0
+	}
+	return c.GetSessionTokenDuration()
+}
+
+// validateMinTTL checks that cached sessions are used for at least half their duration before they're
+// refreshed, so a min TTL close to the session duration doesn't mean a new session, and MFA prompt, for every command
+func (c *ProfileConfig) validateMinTTL() error {
+	duration := c.cachedSessionDuration()
+	if window := c.ExpiryWindow(); duration > 0 && window > duration/2 {
+		return fmt.Errorf("profile %s: the min TTL of %s must be at most half the session duration of %s, or sessions would be refreshed after %s",
+			c.ProfileName, window, duration, (duration - window).Truncate(time.Second))
+	}
+	return nil
+}
+
 func (c *ProfileConfig) GetSessionTokenDuration() time.Duration {
 	if c.IsChained() {
 		return c.ChainedGetSessionTokenDuration
@@ -2,12 +2,18 @@ package vault_test
 
 import (
 	"bytes"
+	"encoding/pem"
 	"fmt"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/99designs/aws-vault/v7/vault"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -616,3 +622,282 @@ source_profile = interim
 		t.Fatalf("Expected transitive_session_tags to be empty, got %+v", baseConfig.TransitiveSessionTags)
 	}
 }
+
+func TestSessionPoliciesOnlyApplyToTargetProfile(t *testing.T) {
+	policyFile := newConfigFile(t, []byte(`{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Action":"s3:*","Resource":"*"}]}`))
+	defer os.Remove(policyFile)
+
+	f := newConfigFile(t, []byte(`
+[profile base]
+
+[profile interim]
+source_profile = base
+role_arn = arn:aws:iam::1111:role/interim
+policy_arns = arn:aws:iam::aws:policy/PowerUserAccess
+policy_file = `+policyFile+`
+
+[profile target]
+source_profile = interim
+role_arn = arn:aws:iam::2222:role/target
+`))
+	defer os.Remove(f)
+
+	configFile, err := vault.LoadConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseConfig := vault.ProfileConfig{PolicyARNs: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}}
+	configLoader := vault.NewConfigLoader(baseConfig, configFile, "target")
+	config, err := configLoader.GetProfileConfig("target")
+	if err != nil {
+		t.Fatalf("Should have found a profile: %v", err)
+	}
+
+	if !reflect.DeepEqual([]string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}, config.PolicyARNs) {
+		t.Fatalf("Expected policy ARNs from the command line, got %+v", config.PolicyARNs)
+	}
+
+	interimConfig := config.SourceProfile
+	if !reflect.DeepEqual([]string{"arn:aws:iam::aws:policy/PowerUserAccess"}, interimConfig.PolicyARNs) {
+		t.Fatalf("Expected policy ARNs from the config file, got %+v", interimConfig.PolicyARNs)
+	}
+	if !strings.Contains(interimConfig.Policy, `"Deny"`) {
+		t.Fatalf("Expected policy to be read from policy_file, got %q", interimConfig.Policy)
+	}
+
+	if config.SourceProfile.SourceProfile.HasSessionPolicies() {
+		t.Fatalf("Expected base profile to have no session policies")
+	}
+}
+
+func TestEcsAllowedRoleARNs(t *testing.T) {
+	f := newConfigFile(t, []byte(`
+[default]
+ecs_allowed_role_arns = arn:aws:iam::111111111111:role/ci-*, arn:aws:iam::222222222222:role/deploy
+
+[profile dev]
+`))
+	defer os.Remove(f)
+
+	configFile, err := vault.LoadConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := vault.NewConfigLoader(vault.ProfileConfig{}, configFile, "dev").GetProfileConfig("dev")
+	if err != nil {
+		t.Fatalf("Should have found a profile: %v", err)
+	}
+
+	expected := []string{"arn:aws:iam::111111111111:role/ci-*", "arn:aws:iam::222222222222:role/deploy"}
+	if !reflect.DeepEqual(expected, config.EcsAllowedRoleARNs) {
+		t.Fatalf("Expected %+v, got %+v", expected, config.EcsAllowedRoleARNs)
+	}
+}
+
+func TestAwsVaultEnvAndEndpoints(t *testing.T) {
+	f := newConfigFile(t, []byte(`
+[default]
+aws_vault_env =
+  AWS_PAGER =
+  TF_VAR_env = default
+
+[profile localstack]
+aws_vault_env =
+  TF_VAR_env = local
+endpoint_url = http://localhost:4566
+services = localstack
+
+[services localstack]
+s3 =
+  endpoint_url = http://localhost:4567
+`))
+	defer os.Remove(f)
+
+	configFile, err := vault.LoadConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := configFile.ProfileNames(); !reflect.DeepEqual(names, []string{"default", "localstack"}) {
+		t.Fatalf("Expected the [services] section not to be a profile, got %v", names)
+	}
+	config, err := vault.NewConfigLoader(vault.ProfileConfig{}, configFile, "localstack").GetProfileConfig("localstack")
+	if err != nil {
+		t.Fatalf("Should have found a profile: %v", err)
+	}
+
+	expectedEnv := map[string]string{"AWS_PAGER": "", "TF_VAR_env": "local"}
+	if !reflect.DeepEqual(expectedEnv, config.Env) {
+		t.Fatalf("Expected %+v, got %+v", expectedEnv, config.Env)
+	}
+	if config.EndpointURL != "http://localhost:4566" {
+		t.Fatalf("Expected endpoint_url http://localhost:4566, got %q", config.EndpointURL)
+	}
+	expectedEndpoints := map[string]string{"s3": "http://localhost:4567"}
+	if !reflect.DeepEqual(expectedEndpoints, config.ServiceEndpointURLs) {
+		t.Fatalf("Expected %+v, got %+v", expectedEndpoints, config.ServiceEndpointURLs)
+	}
+}
+
+func TestEndpointSettings(t *testing.T) {
+	t.Setenv("AWS_CA_BUNDLE", "")
+
+	ts := httptest.NewTLSServer(nil)
+	ts.Close()
+	caBundle := filepath.Join(t.TempDir(), "corp.pem")
+	if err := os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newConfigFile(t, []byte(fmt.Sprintf(`
+[profile fips]
+sts_endpoint_url = https://sts.internal.example.com
+use_fips_endpoint = true
+use_dualstack_endpoint = false
+ca_bundle = %s
+proxy_url = http://proxy.example.com:3128
+
+[profile invalid]
+use_fips_endpoint = sometimes
+
+[profile missing-ca-bundle]
+ca_bundle = %s
+
+[profile invalid-proxy]
+proxy_url = proxy.example.com:3128
+`, caBundle, filepath.Join(t.TempDir(), "missing.pem"))))
+	defer os.Remove(f)
+
+	configFile, err := vault.LoadConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := vault.NewConfigLoader(vault.ProfileConfig{}, configFile, "fips").GetProfileConfig("fips")
+	if err != nil {
+		t.Fatalf("Should have found a profile: %v", err)
+	}
+
+	if config.STSEndpointURL != "https://sts.internal.example.com" {
+		t.Fatalf("Expected sts_endpoint_url https://sts.internal.example.com, got %q", config.STSEndpointURL)
+	}
+	if config.UseFIPSEndpoint != aws.FIPSEndpointStateEnabled {
+		t.Fatalf("Expected FIPS endpoints to be enabled, got %v", config.UseFIPSEndpoint)
+	}
+	if config.UseDualStackEndpoint != aws.DualStackEndpointStateDisabled {
+		t.Fatalf("Expected dual-stack endpoints to be disabled, got %v", config.UseDualStackEndpoint)
+	}
+	if config.CABundle != caBundle {
+		t.Fatalf("Expected ca_bundle %s, got %q", caBundle, config.CABundle)
+	}
+	if config.ProxyURL != "http://proxy.example.com:3128" {
+		t.Fatalf("Expected proxy_url http://proxy.example.com:3128, got %q", config.ProxyURL)
+	}
+
+	for _, profileName := range []string{"invalid", "missing-ca-bundle", "invalid-proxy"} {
+		_, err = vault.NewConfigLoader(vault.ProfileConfig{}, configFile, profileName).GetProfileConfig(profileName)
+		if err == nil {
+			t.Fatalf("Expected an error for profile %s", profileName)
+		}
+	}
+}
+
+func TestRetrySettings(t *testing.T) {
+	f := newConfigFile(t, []byte(`
+[profile resilient]
+region = us-east-1
+retry_mode = adaptive
+max_attempts = 5
+aws_vault_request_timeout = 20s
+sts_failover_regions = us-east-2, us-west-2
+aws_vault_offline_grace = true
+
+[profile invalid]
+retry_mode = sometimes
+`))
+	defer os.Remove(f)
+
+	configFile, err := vault.LoadConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := vault.NewConfigLoader(vault.ProfileConfig{}, configFile, "resilient").GetProfileConfig("resilient")
+	if err != nil {
+		t.Fatalf("Should have found a profile: %v", err)
+	}
+
+	if config.RetryMode != aws.RetryModeAdaptive {
+		t.Fatalf("Expected retry mode adaptive, got %q", config.RetryMode)
+	}
+	if config.MaxAttempts != 5 {
+		t.Fatalf("Expected 5 attempts, got %d", config.MaxAttempts)
+	}
+	if config.RequestTimeout != 20*time.Second {
+		t.Fatalf("Expected a 20s timeout, got %s", config.RequestTimeout)
+	}
+	if expected := []string{"us-east-2", "us-west-2"}; !reflect.DeepEqual(expected, config.STSFailoverRegions) {
+		t.Fatalf("Expected %v, got %v", expected, config.STSFailoverRegions)
+	}
+	if !config.OfflineGrace {
+		t.Fatal("Expected offline grace to be enabled")
+	}
+
+	_, err = vault.NewConfigLoader(vault.ProfileConfig{}, configFile, "invalid").GetProfileConfig("invalid")
+	if err == nil {
+		t.Fatal("Expected an error for an invalid retry_mode")
+	}
+}
+
+func TestMinTTL(t *testing.T) {
+	t.Setenv("AWS_MIN_TTL", "")
+
+	f := newConfigFile(t, []byte(`
+[profile base]
+mfa_serial = arn:aws:iam::111111111111:mfa/jonsmith
+aws_vault_min_ttl = 45m
+
+[profile role]
+source_profile = base
+role_arn = arn:aws:iam::111111111111:role/role
+aws_vault_min_ttl = 20m
+
+[profile short]
+role_arn = arn:aws:iam::111111111111:role/role
+duration_seconds = 900
+aws_vault_min_ttl = 10m
+`))
+	defer os.Remove(f)
+
+	configFile, err := vault.LoadConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := vault.NewConfigLoader(vault.ProfileConfig{}, configFile, "role").GetProfileConfig("role")
+	if err != nil {
+		t.Fatalf("Expected the min TTLs to fit the 1h role and 8h chained sessions: %v", err)
+	}
+	if config.ExpiryWindow() != 20*time.Minute || config.SourceProfile.ExpiryWindow() != 45*time.Minute {
+		t.Fatalf("Expected min TTLs of 20m and 45m, got %s and %s", config.ExpiryWindow(), config.SourceProfile.ExpiryWindow())
+	}
+
+	_, err = vault.NewConfigLoader(vault.ProfileConfig{}, configFile, "base").GetProfileConfig("base")
+	if err == nil {
+		t.Fatal("Expected an error for a 45m min TTL with a 1h session")
+	}
+
+	_, err = vault.NewConfigLoader(vault.ProfileConfig{}, configFile, "short").GetProfileConfig("short")
+	if err == nil {
+		t.Fatal("Expected an error for a 10m min TTL with a 15m session")
+	}
+
+	config, err = vault.NewConfigLoader(vault.ProfileConfig{MinTTL: 5 * time.Minute}, configFile, "short").GetProfileConfig("short")
+	if err != nil {
+		t.Fatalf("Expected the min TTL given on the command line to override the profile: %v", err)
+	}
+	if config.ExpiryWindow() != 5*time.Minute {
+		t.Fatalf("Expected a min TTL of 5m, got %s", config.ExpiryWindow())
+	}
+
+	if window := (&vault.ProfileConfig{}).ExpiryWindow(); window != vault.DefaultMinTTL {
+		t.Fatalf("Expected the default min TTL, got %s", window)
+	}
+}
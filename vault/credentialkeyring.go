@@ -18,7 +18,7 @@ func (ck *CredentialKeyring) Keys() (credentialsNames []string, err error) {
 		return credentialsNames, err
 	}
 	for _, keyName := range allKeys {
-		if !IsSessionKey(keyName) && !IsOIDCTokenKey(keyName) {
+		if !IsSessionKey(keyName) && !IsOIDCTokenKey(keyName) && !IsCognitoIdentityKey(keyName) {
 			credentialsNames = append(credentialsNames, keyName)
 		}
 	}
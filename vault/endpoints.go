@@ -0,0 +1,175 @@
+package vault
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// serviceConfigKey returns the key a service has in a [services] section, e.g. "sso_oidc" for "SSO OIDC"
+func serviceConfigKey(serviceID string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(serviceID))
+}
+
+// EndpointURLFor returns the endpoint configured for the service with sts_endpoint_url, [services] or endpoint_url,
+// or "" to use the default endpoint
+func (c *ProfileConfig) EndpointURLFor(serviceID string) string {
+	if serviceID == sts.ServiceID && c.STSEndpointURL != "" {
+		return c.STSEndpointURL
+	}
+	if u, ok := c.ServiceEndpointURLs[serviceConfigKey(serviceID)]; ok && u != "" {
+		return u
+	}
+	return c.EndpointURL
+}
+
+// endpointResolver resolves the profile's custom endpoints, then the legacy STS endpoints. Other endpoints are
+// resolved by the SDK, using FIPS and dual-stack endpoints if the profile asks for them
+func (c *ProfileConfig) endpointResolver() aws.EndpointResolverWithOptionsFunc {
+	legacy := getSTSEndpointResolver(c.STSRegionalEndpoints)
+	return func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		if u := c.EndpointURLFor(service); u != "" {
+			log.Printf("Using endpoint %s for %s", u, service)
+			return aws.Endpoint{
+				URL:               u,
+				SigningRegion:     region,
+				HostnameImmutable: true,
+				Source:            aws.EndpointSourceCustom,
+			}, nil
+		}
+		return legacy(service, region, options...)
+	}
+}
+
+// endpointStates passes use_fips_endpoint and use_dualstack_endpoint to the SDK's service clients, which look
+// for them in aws.Config.ConfigSources
+type endpointStates struct {
+	fips      aws.FIPSEndpointState
+	dualStack aws.DualStackEndpointState
+}
+
+func (s endpointStates) GetUseFIPSEndpoint(context.Context) (aws.FIPSEndpointState, bool, error) {
+	return s.fips, s.fips != aws.FIPSEndpointStateUnset, nil
+}
+
+func (s endpointStates) GetUseDualStackEndpoint(context.Context) (aws.DualStackEndpointState, bool, error) {
+	return s.dualStack, s.dualStack != aws.DualStackEndpointStateUnset, nil
+}
+
+func (c *ProfileConfig) configSources() []interface{} {
+	return []interface{}{endpointStates{fips: c.UseFIPSEndpoint, dualStack: c.UseDualStackEndpoint}}
+}
+
+// parseFIPSEndpointState parses a use_fips_endpoint setting, which is unset if empty
+func parseFIPSEndpointState(s string) (aws.FIPSEndpointState, error) {
+	if s == "" {
+		return aws.FIPSEndpointStateUnset, nil
+	}
+	enabled, err := strconv.ParseBool(s)
+	if err != nil {
+		return aws.FIPSEndpointStateUnset, fmt.Errorf("'%s' must be true or false", s)
+	}
+	if enabled {
+		return aws.FIPSEndpointStateEnabled, nil
+	}
+	return aws.FIPSEndpointStateDisabled, nil
+}
+
+// parseDualStackEndpointState parses a use_dualstack_endpoint setting, which is unset if empty
+func parseDualStackEndpointState(s string) (aws.DualStackEndpointState, error) {
+	if s == "" {
+		return aws.DualStackEndpointStateUnset, nil
+	}
+	enabled, err := strconv.ParseBool(s)
+	if err != nil {
+		return aws.DualStackEndpointStateUnset, fmt.Errorf("'%s' must be true or false", s)
+	}
+	if enabled {
+		return aws.DualStackEndpointStateEnabled, nil
+	}
+	return aws.DualStackEndpointStateDisabled, nil
+}
+
+// configureTransport makes the transport trust the profile's ca_bundle and use its proxy_url
+func (c *ProfileConfig) configureTransport(tr *http.Transport) {
+	if c.ProxyURL != "" {
+		if u, err := parseProxyURL(c.ProxyURL); err == nil {
+			tr.Proxy = http.ProxyURL(u)
+		} else {
+			log.Printf("Ignoring proxy_url: %s", err.Error())
+		}
+	}
+	if c.CABundle != "" {
+		roots, err := loadCABundle(c.CABundle)
+		if err != nil {
+			log.Printf("Ignoring ca_bundle: %s", err.Error())
+			return
+		}
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		tr.TLSClientConfig.RootCAs = roots
+	}
+}
+
+func (c *ProfileConfig) hasCustomTransport() bool {
+	return c.CABundle != "" || c.ProxyURL != ""
+}
+
+// awsHTTPClient returns the HTTP client for the SDK's service clients, or nil to use the SDK's default
+func (c *ProfileConfig) awsHTTPClient() aws.HTTPClient {
+	if !c.hasCustomTransport() {
+		return nil
+	}
+	return awshttp.NewBuildableClient().WithTransportOptions(c.configureTransport)
+}
+
+// HTTPClient returns an HTTP client that uses the profile's ca_bundle, proxy_url and request timeout
+func (c *ProfileConfig) HTTPClient() *http.Client {
+	client := &http.Client{Timeout: c.requestTimeout()}
+	if c.hasCustomTransport() {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		c.configureTransport(tr)
+		client.Transport = tr
+	}
+	return client
+}
+
+// parseProxyURL parses a proxy_url, which must be an absolute URL such as http://proxy.example.com:3128
+func parseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("'%s' must be a URL with a scheme and host", s)
+	}
+	return u, nil
+}
+
+// loadCABundle returns the system's certificate pool with the certificates in the file added
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return roots, nil
+}
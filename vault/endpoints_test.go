@@ -0,0 +1,62 @@
+package vault
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+func TestEndpointURLFor(t *testing.T) {
+	config := &ProfileConfig{
+		EndpointURL:         "http://localhost:4566",
+		STSEndpointURL:      "http://localhost:4568",
+		ServiceEndpointURLs: map[string]string{"sso_oidc": "http://localhost:4567"},
+	}
+
+	for serviceID, expected := range map[string]string{
+		sts.ServiceID: "http://localhost:4568",
+		"SSO OIDC":    "http://localhost:4567",
+		"SSO":         "http://localhost:4566",
+	} {
+		if u := config.EndpointURLFor(serviceID); u != expected {
+			t.Errorf("Expected endpoint %s for %s, got %s", expected, serviceID, u)
+		}
+	}
+
+	if u := (&ProfileConfig{}).EndpointURLFor(sts.ServiceID); u != "" {
+		t.Errorf("Expected the default endpoint, got %s", u)
+	}
+}
+
+func TestNewAwsConfigUsesSTSEndpointURLAndCABundle(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(getCallerIdentityResponse))
+	}))
+	defer ts.Close()
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caBundle, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ProfileConfig{STSEndpointURL: ts.URL, CABundle: caBundle}
+	creds := credentials.NewStaticCredentialsProvider("AKIATEST", "secret", "")
+	client := sts.NewFromConfig(NewAwsConfigWithCredsProvider(creds, "us-east-1", config))
+
+	out, err := client.GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(out.Account) != "111111111111" {
+		t.Fatalf("Expected account 111111111111, got %s", aws.ToString(out.Account))
+	}
+}
@@ -13,9 +13,11 @@ const allowAllIAMPolicy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow
 
 // FederationTokenProvider retrieves temporary credentials from STS using GetFederationToken
 type FederationTokenProvider struct {
-	StsClient *sts.Client
-	Name      string
-	Duration  time.Duration
+	StsClient  *sts.Client
+	Name       string
+	Duration   time.Duration
+	PolicyARNs []string
+	Policy     string
 }
 
 func (f *FederationTokenProvider) name() string {
@@ -28,11 +30,19 @@ func (f *FederationTokenProvider) name() string {
 
 // Retrieve generates a new set of temporary credentials using STS GetFederationToken
 func (f *FederationTokenProvider) Retrieve(ctx context.Context) (creds aws.Credentials, err error) {
-	resp, err := f.StsClient.GetFederationToken(ctx, &sts.GetFederationTokenInput{
+	input := &sts.GetFederationTokenInput{
 		Name:            aws.String(f.name()),
 		DurationSeconds: aws.Int32(int32(f.Duration.Seconds())),
-		Policy:          aws.String(allowAllIAMPolicy),
-	})
+		PolicyArns:      policyDescriptors(f.PolicyARNs),
+	}
+	if f.Policy != "" {
+		input.Policy = aws.String(f.Policy)
+	} else if len(f.PolicyARNs) == 0 {
+		// Without a session policy the federated session would have no permissions
+		input.Policy = aws.String(allowAllIAMPolicy)
+	}
+
+	resp, err := f.StsClient.GetFederationToken(ctx, input)
 	if err != nil {
 		return creds, err
 	}
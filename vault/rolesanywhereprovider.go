@@ -0,0 +1,429 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/99designs/aws-vault/v7/prompt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const rolesAnywhereSigningName = "rolesanywhere"
+
+// rolesAnywhereSigningHelper is the AWS signing helper, used for certificates and keys in a PKCS#11 token
+var rolesAnywhereSigningHelper = "aws_signing_helper"
+
+// RolesAnywhereProvider retrieves temporary credentials from IAM Roles Anywhere using an X.509 certificate
+type RolesAnywhereProvider struct {
+	CertificateFile  string
+	PrivateKeyFile   string
+	PKCS12File       string
+	PassphrasePrompt prompt.Func
+	PKCS11Lib        string
+	Certificate      *x509.Certificate
+	CertificateChain []*x509.Certificate
+	PrivateKey       crypto.Signer
+	TrustAnchorARN   string
+	ProfileARN       string
+	RoleARN          string
+	RoleSessionName  string
+	Region           string
+	Duration         time.Duration
+
+	// Endpoint overrides the Roles Anywhere endpoint, e.g. https://rolesanywhere.us-east-1.amazonaws.com
+	Endpoint   string
+	HTTPClient *http.Client
+}
+
+// Retrieve generates a new set of temporary credentials using Roles Anywhere CreateSession
+func (p *RolesAnywhereProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.RetrieveStsCredentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		CanExpire:       true,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
+
+type rolesAnywhereCreateSessionOutput struct {
+	CredentialSet []struct {
+		Credentials struct {
+			AccessKeyID     string    `json:"accessKeyId"`
+			SecretAccessKey string    `json:"secretAccessKey"`
+			SessionToken    string    `json:"sessionToken"`
+			Expiration      time.Time `json:"expiration"`
+		} `json:"credentials"`
+	} `json:"credentialSet"`
+	Message string `json:"message"`
+}
+
+func (p *RolesAnywhereProvider) RetrieveStsCredentials(ctx context.Context) (*ststypes.Credentials, error) {
+	if p.usesPKCS11() {
+		return p.retrieveWithSigningHelper(ctx)
+	}
+
+	if p.Certificate == nil {
+		var err error
+		p.Certificate, p.CertificateChain, p.PrivateKey, err = LoadRolesAnywhereCertificate(p.CertificateFile, p.PrivateKeyFile, p.PKCS12File, p.PassphrasePrompt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	input := map[string]interface{}{
+		"durationSeconds": int(p.Duration.Seconds()),
+		"profileArn":      p.ProfileARN,
+		"roleArn":         p.RoleARN,
+		"trustAnchorArn":  p.TrustAnchorARN,
+	}
+	if p.RoleSessionName != "" {
+		input["roleSessionName"] = p.RoleSessionName
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.endpoint(), "/")+"/sessions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if err = p.signRequest(req, body, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out rolesAnywhereCreateSessionOutput
+	if err = json.Unmarshal(respBody, &out); err != nil && resp.StatusCode == http.StatusCreated {
+		return nil, fmt.Errorf("invalid response from Roles Anywhere: %w", err)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		if out.Message != "" {
+			return nil, fmt.Errorf("Roles Anywhere CreateSession failed with %s: %s", resp.Status, out.Message)
+		}
+		return nil, fmt.Errorf("Roles Anywhere CreateSession failed with %s", resp.Status)
+	}
+	if len(out.CredentialSet) == 0 {
+		return nil, errors.New("Roles Anywhere CreateSession returned no credentials")
+	}
+
+	c := out.CredentialSet[0].Credentials
+	log.Printf("Generated credentials %s using Roles Anywhere, expires in %s", FormatKeyForDisplay(c.AccessKeyID), time.Until(c.Expiration).String())
+
+	return &ststypes.Credentials{
+		AccessKeyId:     aws.String(c.AccessKeyID),
+		SecretAccessKey: aws.String(c.SecretAccessKey),
+		SessionToken:    aws.String(c.SessionToken),
+		Expiration:      aws.Time(c.Expiration),
+	}, nil
+}
+
+func (p *RolesAnywhereProvider) usesPKCS11() bool {
+	return isPKCS11URI(p.CertificateFile) || isPKCS11URI(p.PrivateKeyFile)
+}
+
+// signingHelperArgs returns the arguments for the AWS signing helper's credential-process command
+func (p *RolesAnywhereProvider) signingHelperArgs() []string {
+	args := []string{"credential-process", "--certificate", p.CertificateFile}
+	if p.PrivateKeyFile != "" {
+		args = append(args, "--private-key", p.PrivateKeyFile)
+	}
+	if p.PKCS11Lib != "" {
+		args = append(args, "--pkcs11-lib", p.PKCS11Lib)
+	}
+	args = append(args,
+		"--trust-anchor-arn", p.TrustAnchorARN,
+		"--profile-arn", p.ProfileARN,
+		"--role-arn", p.RoleARN,
+		"--region", p.region(),
+		"--session-duration", strconv.Itoa(int(p.Duration.Seconds())),
+	)
+	if p.RoleSessionName != "" {
+		args = append(args, "--role-session-name", p.RoleSessionName)
+	}
+	if p.Endpoint != "" {
+		args = append(args, "--endpoint", p.Endpoint)
+	}
+	return args
+}
+
+// retrieveWithSigningHelper gets credentials with the AWS signing helper, which can sign with a key in a PKCS#11
+// token. The helper asks for the token's PIN on the terminal unless the URI has a pin-value
+func (p *RolesAnywhereProvider) retrieveWithSigningHelper(ctx context.Context) (*ststypes.Credentials, error) {
+	cmd := exec.CommandContext(ctx, rolesAnywhereSigningHelper, p.signingHelperArgs()...)
+	cmd.Env = os.Environ()
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	log.Printf("Running %s credential-process for the PKCS#11 certificate", rolesAnywhereSigningHelper)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %s: %w", rolesAnywhereSigningHelper, err)
+	}
+
+	process := &CredentialProcessProvider{CredentialProcess: rolesAnywhereSigningHelper}
+	creds, err := process.callCredentialProcessWith(ctx, func(string) (string, error) { return string(output), nil })
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Generated credentials %s using Roles Anywhere, expires in %s", FormatKeyForDisplay(aws.ToString(creds.AccessKeyId)), time.Until(aws.ToTime(creds.Expiration)).String())
+
+	return creds, nil
+}
+
+func (p *RolesAnywhereProvider) region() string {
+	// arn:aws:rolesanywhere:<region>:<account>:trust-anchor/<id>
+	if arnParts := strings.Split(p.TrustAnchorARN, ":"); len(arnParts) > 3 && arnParts[3] != "" {
+		return arnParts[3]
+	}
+	return p.Region
+}
+
+func (p *RolesAnywhereProvider) endpoint() string {
+	if p.Endpoint != "" {
+		return p.Endpoint
+	}
+	return fmt.Sprintf("https://rolesanywhere.%s.amazonaws.com", p.region())
+}
+
+func (p *RolesAnywhereProvider) signingAlgorithm() (string, error) {
+	switch p.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		return "AWS4-X509-RSA-SHA256", nil
+	case *ecdsa.PublicKey:
+		return "AWS4-X509-ECDSA-SHA256", nil
+	}
+	return "", fmt.Errorf("unsupported private key type %T", p.PrivateKey.Public())
+}
+
+// signRequest signs the request as described in
+// https://docs.aws.amazon.com/rolesanywhere/latest/userguide/authentication-sign-process.html
+func (p *RolesAnywhereProvider) signRequest(req *http.Request, body []byte, now time.Time) error {
+	algorithm, err := p.signingAlgorithm()
+	if err != nil {
+		return err
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-X509", base64.StdEncoding.EncodeToString(p.Certificate.Raw))
+	signedHeaders := []string{"content-type", "host", "x-amz-date", "x-amz-x509"}
+
+	if len(p.CertificateChain) > 0 {
+		chain := []string{}
+		for _, c := range p.CertificateChain {
+			chain = append(chain, base64.StdEncoding.EncodeToString(c.Raw))
+		}
+		req.Header.Set("X-Amz-X509-Chain", strings.Join(chain, ","))
+		signedHeaders = append(signedHeaders, "x-amz-x509-chain")
+	}
+
+	canonicalHeaders := ""
+	for _, h := range signedHeaders {
+		canonicalHeaders += h + ":" + strings.TrimSpace(req.Header.Get(h)) + "\n"
+	}
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		strings.Join(signedHeaders, ";"),
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	credentialScope := fmt.Sprintf("%s/%s/%s/aws4_request", now.Format("20060102"), p.region(), rolesAnywhereSigningName)
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		algorithm,
+		amzDate,
+		credentialScope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := p.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("signing request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm,
+		p.Certificate.SerialNumber.String(),
+		credentialScope,
+		strings.Join(signedHeaders, ";"),
+		hex.EncodeToString(signature),
+	))
+
+	return nil
+}
+
+func canonicalPath(u *url.URL) string {
+	if p := u.EscapedPath(); p != "" {
+		return p
+	}
+	return "/"
+}
+
+// isPKCS11URI reports whether a certificate or key is given as a PKCS#11 URI, as the AWS signing helper allows
+func isPKCS11URI(s string) bool {
+	return strings.HasPrefix(strings.ToLower(s), "pkcs11:")
+}
+
+// LoadRolesAnywhereCertificate reads the certificate, chain and private key from PEM files, or from a PKCS#12 file.
+// The PKCS#12 passphrase is read from AWS_VAULT_PKCS12_PASSPHRASE, or asked for with passphrasePrompt if it's set
+func LoadRolesAnywhereCertificate(certFile, keyFile, pkcs12File string, passphrasePrompt prompt.Func) (*x509.Certificate, []*x509.Certificate, crypto.Signer, error) {
+	if pkcs12File != "" {
+		return loadPKCS12(pkcs12File, passphrasePrompt)
+	}
+
+	certs, err := readPEMCertificates(certFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(certs) == 0 {
+		return nil, nil, nil, fmt.Errorf("no certificate found in %s", certFile)
+	}
+
+	key, err := readPEMPrivateKey(keyFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return certs[0], certs[1:], key, nil
+}
+
+func readPEMCertificates(file string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse certificate in %s: %w", file, err)
+		}
+		certs = append(certs, c)
+	}
+
+	return certs, nil
+}
+
+func readPEMPrivateKey(file string) (crypto.Signer, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", file)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key in %s: %w", file, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T in %s", key, file)
+	}
+
+	return signer, nil
+}
+
+func loadPKCS12(file string, passphrasePrompt prompt.Func) (*x509.Certificate, []*x509.Certificate, crypto.Signer, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to read PKCS#12 file: %w", err)
+	}
+
+	password, ok := os.LookupEnv("AWS_VAULT_PKCS12_PASSPHRASE")
+	if !ok {
+		if passphrasePrompt == nil {
+			return nil, nil, nil, fmt.Errorf("the prompt driver can't ask for the passphrase of %s, set AWS_VAULT_PKCS12_PASSPHRASE or use a different prompt driver", file)
+		}
+		password, err = passphrasePrompt(file)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to read the passphrase of %s: %w", file, err)
+		}
+	}
+
+	key, cert, chain, err := pkcs12.DecodeChain(b, password)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to decode PKCS#12 file %s: %w", file, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("unsupported private key type %T in %s", key, file)
+	}
+
+	return cert, chain, signer, nil
+}
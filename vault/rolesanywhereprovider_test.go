@@ -0,0 +1,263 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/99designs/keyring"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func newTestCertificate(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(4242),
+		Subject:      pkix.Name{CommonName: "build-agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestRolesAnywhereProviderRetrieve(t *testing.T) {
+	cert, key := newTestCertificate(t)
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/sessions" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		auth := r.Header.Get("Authorization")
+		prefix := fmt.Sprintf("AWS4-X509-ECDSA-SHA256 Credential=4242/%s/us-west-2/rolesanywhere/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-x509, Signature=", time.Now().UTC().Format("20060102"))
+		if !strings.HasPrefix(auth, prefix) {
+			t.Errorf("Unexpected Authorization header %q", auth)
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		payloadHash := sha256.Sum256(body)
+		canonicalRequest := strings.Join([]string{
+			"POST", "/sessions", "",
+			"content-type:application/json\nhost:" + r.Host + "\nx-amz-date:" + r.Header.Get("X-Amz-Date") + "\nx-amz-x509:" + r.Header.Get("X-Amz-X509") + "\n",
+			"content-type;host;x-amz-date;x-amz-x509",
+			hex.EncodeToString(payloadHash[:]),
+		}, "\n")
+		canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+		stringToSign := strings.Join([]string{
+			"AWS4-X509-ECDSA-SHA256",
+			r.Header.Get("X-Amz-Date"),
+			fmt.Sprintf("%s/us-west-2/rolesanywhere/aws4_request", time.Now().UTC().Format("20060102")),
+			hex.EncodeToString(canonicalRequestHash[:]),
+		}, "\n")
+		digest := sha256.Sum256([]byte(stringToSign))
+		signature, _ := hex.DecodeString(strings.TrimPrefix(auth, prefix))
+		if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+			t.Errorf("Signature did not verify")
+		}
+
+		var input map[string]interface{}
+		if err := json.Unmarshal(body, &input); err != nil {
+			t.Error(err)
+		}
+		if input["roleArn"] != "arn:aws:iam::111111111111:role/builder" || input["durationSeconds"] != float64(3600) {
+			t.Errorf("Unexpected CreateSession input %v", input)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"credentialSet":[{"credentials":{"accessKeyId":"ASIAEXAMPLE1234","secretAccessKey":"secret","sessionToken":"token","expiration":"%s"}}]}`, expiration.Format(time.RFC3339))
+	}))
+	defer ts.Close()
+
+	p := &RolesAnywhereProvider{
+		Certificate:    cert,
+		PrivateKey:     key,
+		TrustAnchorARN: "arn:aws:rolesanywhere:us-west-2:111111111111:trust-anchor/abc",
+		ProfileARN:     "arn:aws:rolesanywhere:us-west-2:111111111111:profile/def",
+		RoleARN:        "arn:aws:iam::111111111111:role/builder",
+		Duration:       time.Hour,
+		Endpoint:       ts.URL,
+	}
+
+	creds, err := p.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIAEXAMPLE1234" || creds.SessionToken != "token" || !creds.Expires.Equal(expiration) {
+		t.Fatalf("Unexpected credentials %v", creds)
+	}
+}
+
+func TestRolesAnywhereSessionKey(t *testing.T) {
+	p, err := NewRolesAnywhereProvider(keyring.NewArrayKeyring(nil), &ProfileConfig{
+		ProfileName:             "rolesanywhere",
+		RoleARN:                 "arn:aws:iam::111111111111:role/workload",
+		RolesAnywhereCert:       "cert.pem",
+		RolesAnywherePrivateKey: "key.pem",
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := p.(*CachedSessionProvider).SessionKey
+	if key.MfaSerial != "" {
+		t.Fatalf("Expected no MFA serial in the session key, got %q", key.MfaSerial)
+	}
+	if !strings.HasPrefix(key.Type, "rolesanywhere.CreateSession+role-") {
+		t.Fatalf("Expected the session type to include the role, got %q", key.Type)
+	}
+}
+
+func TestLoadPKCS12WithChain(t *testing.T) {
+	cert, key := newTestCertificate(t)
+	intermediate, _ := newTestCertificate(t)
+
+	pfx, err := pkcs12.Modern.Encode(key, cert, []*x509.Certificate{intermediate}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "build-agent.p12")
+	if err = os.WriteFile(file, pfx, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_VAULT_PKCS12_PASSPHRASE", "secret")
+
+	leaf, chain, _, err := LoadRolesAnywhereCertificate("", "", file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leaf.Equal(cert) {
+		t.Fatal("Expected the leaf certificate")
+	}
+	if len(chain) != 1 || !chain[0].Equal(intermediate) {
+		t.Fatalf("Expected the intermediate certificate in the chain, got %d certificates", len(chain))
+	}
+}
+
+func TestLoadPKCS12PassphrasePrompt(t *testing.T) {
+	cert, key := newTestCertificate(t)
+	pfx, err := pkcs12.Modern.Encode(key, cert, nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "build-agent.p12")
+	if err = os.WriteFile(file, pfx, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_VAULT_PKCS12_PASSPHRASE", "")
+	os.Unsetenv("AWS_VAULT_PKCS12_PASSPHRASE")
+
+	prompted := ""
+	leaf, _, _, err := LoadRolesAnywhereCertificate("", "", file, func(name string) (string, error) {
+		prompted = name
+		return "secret", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leaf.Equal(cert) || prompted != file {
+		t.Fatalf("Expected to be prompted for the passphrase of %s, got %q", file, prompted)
+	}
+
+	_, _, _, err = LoadRolesAnywhereCertificate("", "", file, nil)
+	if err == nil || !strings.Contains(err.Error(), "AWS_VAULT_PKCS12_PASSPHRASE") {
+		t.Fatalf("Expected an error without a passphrase prompt, got %v", err)
+	}
+}
+
+func TestRolesAnywherePKCS11UsesSigningHelper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake signing helper is a shell script")
+	}
+
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	helper := filepath.Join(dir, "aws_signing_helper")
+	script := fmt.Sprintf(`#!/bin/sh
+printf '%%s\n' "$@" > %s
+echo '{"Version":1,"AccessKeyId":"ASIAPKCS11","SecretAccessKey":"secret","SessionToken":"token","Expiration":"2030-01-01T00:00:00Z"}'
+`, argsFile)
+	if err := os.WriteFile(helper, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer func(h string) { rolesAnywhereSigningHelper = h }(rolesAnywhereSigningHelper)
+	rolesAnywhereSigningHelper = helper
+
+	p, err := NewRolesAnywhereProvider(keyring.NewArrayKeyring(nil), &ProfileConfig{
+		ProfileName:            "rolesanywhere",
+		TrustAnchorARN:         "arn:aws:rolesanywhere:eu-west-1:123456789012:trust-anchor/abc",
+		ProfileARN:             "arn:aws:rolesanywhere:eu-west-1:123456789012:profile/def",
+		RoleARN:                "arn:aws:iam::123456789012:role/build-agent",
+		RolesAnywhereCert:      "pkcs11:token=build-agent;object=cert",
+		RolesAnywherePKCS11Lib: "/usr/lib/softhsm/libsofthsm2.so",
+		AssumeRoleDuration:     time.Hour,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := p.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIAPKCS11" || creds.SessionToken != "token" || !creds.Expires.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected credentials %+v", creds)
+	}
+
+	b, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"credential-process",
+		"--certificate", "pkcs11:token=build-agent;object=cert",
+		"--pkcs11-lib", "/usr/lib/softhsm/libsofthsm2.so",
+		"--trust-anchor-arn", "arn:aws:rolesanywhere:eu-west-1:123456789012:trust-anchor/abc",
+		"--profile-arn", "arn:aws:rolesanywhere:eu-west-1:123456789012:profile/def",
+		"--role-arn", "arn:aws:iam::123456789012:role/build-agent",
+		"--region", "eu-west-1",
+		"--session-duration", "3600",
+	}, "\n") + "\n"
+	if string(b) != expected {
+		t.Fatalf("Expected the signing helper args\n%s\ngot\n%s", expected, b)
+	}
+}
+
+func TestRolesAnywhereRejectsPKCS11InPKCS12(t *testing.T) {
+	_, err := NewRolesAnywhereProvider(keyring.NewArrayKeyring(nil), &ProfileConfig{
+		ProfileName:         "rolesanywhere",
+		RolesAnywherePKCS12: "pkcs11:token=build-agent;object=cert",
+	}, false)
+	if err == nil || !strings.Contains(err.Error(), "rolesanywhere_pkcs12 must be a file") {
+		t.Fatalf("Expected an error for a PKCS#11 URI in rolesanywhere_pkcs12, got %v", err)
+	}
+}
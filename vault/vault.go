@@ -2,39 +2,55 @@ package vault
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
-	"os"
-	"time"
+	"strings"
 
+	"github.com/99designs/aws-vault/v7/prompt"
 	"github.com/99designs/keyring"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentity"
 	"github.com/aws/aws-sdk-go-v2/service/sso"
 	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
-var defaultExpirationWindow = 5 * time.Minute
-
-func init() {
-	if d, err := time.ParseDuration(os.Getenv("AWS_MIN_TTL")); err == nil {
-		defaultExpirationWindow = d
-	}
-}
-
-func NewAwsConfig(region, stsRegionalEndpoints string) aws.Config {
+// NewAwsConfig returns the SDK config for the region, with the endpoints, TLS, proxy, retry, timeout
+// and STS failover settings of the profile
+func NewAwsConfig(region string, config *ProfileConfig) aws.Config {
 	return aws.Config{
 		Region:                      region,
-		EndpointResolverWithOptions: getSTSEndpointResolver(stsRegionalEndpoints),
+		EndpointResolverWithOptions: config.endpointResolver(),
+		HTTPClient:                  config.awsHTTPClient(),
+		ConfigSources:               config.configSources(),
+		RetryMode:                   config.RetryMode,
+		RetryMaxAttempts:            config.MaxAttempts,
+		APIOptions:                  config.apiOptions(region),
 	}
 }
 
-func NewAwsConfigWithCredsProvider(credsProvider aws.CredentialsProvider, region, stsRegionalEndpoints string) aws.Config {
-	return aws.Config{
-		Region:                      region,
-		Credentials:                 credsProvider,
-		EndpointResolverWithOptions: getSTSEndpointResolver(stsRegionalEndpoints),
+func NewAwsConfigWithCredsProvider(credsProvider aws.CredentialsProvider, region string, config *ProfileConfig) aws.Config {
+	cfg := NewAwsConfig(region, config)
+	cfg.Credentials = credsProvider
+	return cfg
+}
+
+// sessionPolicySuffix distinguishes cached sessions that were created with session policies
+func sessionPolicySuffix(config *ProfileConfig) string {
+	if !config.HasSessionPolicies() {
+		return ""
 	}
+	h := sha256.Sum256([]byte(strings.Join(config.PolicyARNs, ",") + "\n" + config.Policy))
+	return "+policy-" + hex.EncodeToString(h[:4])
+}
+
+// sessionSourceSuffix distinguishes cached sessions of the same type that were created from different
+// sources, such as roles, without putting them in the session's MFA serial
+func sessionSourceSuffix(kind string, source string) string {
+	h := sha256.Sum256([]byte(source))
+	return "+" + kind + "-" + hex.EncodeToString(h[:4])
 }
 
 func FormatKeyForDisplay(k string) string {
@@ -52,7 +68,7 @@ func NewMasterCredentialsProvider(k *CredentialKeyring, credentialsName string)
 }
 
 func NewSessionTokenProvider(credsProvider aws.CredentialsProvider, k keyring.Keyring, config *ProfileConfig, useSessionCache bool) (aws.CredentialsProvider, error) {
-	cfg := NewAwsConfigWithCredsProvider(credsProvider, config.Region, config.STSRegionalEndpoints)
+	cfg := NewAwsConfigWithCredsProvider(credsProvider, config.Region, config)
 
 	sessionTokenProvider := &SessionTokenProvider{
 		StsClient: sts.NewFromConfig(cfg),
@@ -68,7 +84,8 @@ func NewSessionTokenProvider(credsProvider aws.CredentialsProvider, k keyring.Ke
 				MfaSerial:   config.MfaSerial,
 			},
 			Keyring:         &SessionKeyring{Keyring: k},
-			ExpiryWindow:    defaultExpirationWindow,
+			ExpiryWindow:    config.ExpiryWindow(),
+			OfflineGrace:    config.OfflineGrace,
 			SessionProvider: sessionTokenProvider,
 		}, nil
 	}
@@ -78,7 +95,7 @@ func NewSessionTokenProvider(credsProvider aws.CredentialsProvider, k keyring.Ke
 
 // NewAssumeRoleProvider returns a provider that generates credentials using AssumeRole
 func NewAssumeRoleProvider(credsProvider aws.CredentialsProvider, k keyring.Keyring, config *ProfileConfig, useSessionCache bool) (aws.CredentialsProvider, error) {
-	cfg := NewAwsConfigWithCredsProvider(credsProvider, config.Region, config.STSRegionalEndpoints)
+	cfg := NewAwsConfigWithCredsProvider(credsProvider, config.Region, config)
 
 	p := &AssumeRoleProvider{
 		StsClient:         sts.NewFromConfig(cfg),
@@ -89,18 +106,105 @@ func NewAssumeRoleProvider(credsProvider aws.CredentialsProvider, k keyring.Keyr
 		Tags:              config.SessionTags,
 		TransitiveTagKeys: config.TransitiveSessionTags,
 		SourceIdentity:    config.SourceIdentity,
+		PolicyARNs:        config.PolicyARNs,
+		Policy:            config.Policy,
 		Mfa:               NewMfa(config),
 	}
 
 	if useSessionCache && config.MfaSerial != "" {
 		return &CachedSessionProvider{
 			SessionKey: SessionMetadata{
-				Type:        "sts.AssumeRole",
+				Type:        "sts.AssumeRole" + sessionPolicySuffix(config),
+				ProfileName: config.ProfileName,
+				MfaSerial:   config.MfaSerial,
+			},
+			Keyring:         &SessionKeyring{Keyring: k},
+			ExpiryWindow:    config.ExpiryWindow(),
+			OfflineGrace:    config.OfflineGrace,
+			SessionProvider: p,
+		}, nil
+	}
+
+	return p, nil
+}
+
+// RoleHop is a role to assume on top of a profile's credentials, given on the command line rather than in the config
+type RoleHop struct {
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+	SessionTags     map[string]string
+	PolicyARNs      []string
+	Policy          string
+}
+
+// NewRoleHopsProvider returns a provider that assumes each of the roles in turn, starting from credsProvider
+func NewRoleHopsProvider(credsProvider aws.CredentialsProvider, config *ProfileConfig, hops []RoleHop) *AssumeRoleProvider {
+	var p *AssumeRoleProvider
+	for _, hop := range hops {
+		duration := config.AssumeRoleDuration
+		if isRoleCredentialsProvider(credsProvider) && duration > roleChainingMaximumDuration {
+			log.Printf("Using duration %s for role %s, the AWS maximum for role chaining", roleChainingMaximumDuration, hop.RoleARN)
+			duration = roleChainingMaximumDuration
+		}
+
+		log.Printf("profile %s: using AssumeRole %s", config.ProfileName, hop.RoleARN)
+		p = &AssumeRoleProvider{
+			StsClient:       sts.NewFromConfig(NewAwsConfigWithCredsProvider(credsProvider, config.Region, config)),
+			RoleARN:         hop.RoleARN,
+			RoleSessionName: hop.RoleSessionName,
+			ExternalID:      hop.ExternalID,
+			Duration:        duration,
+			Tags:            hop.SessionTags,
+			PolicyARNs:      hop.PolicyARNs,
+			Policy:          hop.Policy,
+		}
+		credsProvider = p
+	}
+	return p
+}
+
+// isRoleCredentialsProvider reports whether the provider's credentials are for a role, so assuming another role chains them
+func isRoleCredentialsProvider(credsProvider aws.CredentialsProvider) bool {
+	if isMasterCredentialsProvider(credsProvider) {
+		return false
+	}
+	switch p := credsProvider.(type) {
+	case *SessionTokenProvider:
+		return false
+	case *CachedSessionProvider:
+		return p.SessionKey.Type != "sts.GetSessionToken"
+	}
+	return true
+}
+
+// NewAssumeRootProvider returns a provider that generates task-scoped root credentials using AssumeRoot
+func NewAssumeRootProvider(credsProvider aws.CredentialsProvider, k keyring.Keyring, config *ProfileConfig, useSessionCache bool) (aws.CredentialsProvider, error) {
+	if config.TaskPolicyARN == "" {
+		return nil, fmt.Errorf("profile %s: task_policy_arn must be set with target_principal", config.ProfileName)
+	}
+
+	if config.Region == "" {
+		return nil, fmt.Errorf("profile %s: AssumeRoot requires a regional STS endpoint, set a region for the profile", config.ProfileName)
+	}
+
+	p := &AssumeRootProvider{
+		StsClient:       sts.NewFromConfig(NewAwsConfigWithCredsProvider(credsProvider, config.Region, config)),
+		TargetPrincipal: config.TargetPrincipal,
+		TaskPolicyARN:   config.TaskPolicyARN,
+		Duration:        config.AssumeRoleDuration,
+	}
+
+	if useSessionCache {
+		return &CachedSessionProvider{
+			SessionKey: SessionMetadata{
+				Type:        "sts.AssumeRoot",
 				ProfileName: config.ProfileName,
 				MfaSerial:   config.MfaSerial,
 			},
 			Keyring:         &SessionKeyring{Keyring: k},
-			ExpiryWindow:    defaultExpirationWindow,
+			ExpiryWindow:    config.ExpiryWindow(),
+			OfflineGrace:    config.OfflineGrace,
 			SessionProvider: p,
 		}, nil
 	}
@@ -111,7 +215,7 @@ func NewAssumeRoleProvider(credsProvider aws.CredentialsProvider, k keyring.Keyr
 // NewAssumeRoleWithWebIdentityProvider returns a provider that generates
 // credentials using AssumeRoleWithWebIdentity
 func NewAssumeRoleWithWebIdentityProvider(k keyring.Keyring, config *ProfileConfig, useSessionCache bool) (aws.CredentialsProvider, error) {
-	cfg := NewAwsConfig(config.Region, config.STSRegionalEndpoints)
+	cfg := NewAwsConfig(config.Region, config)
 
 	p := &AssumeRoleWithWebIdentityProvider{
 		StsClient:               sts.NewFromConfig(cfg),
@@ -120,16 +224,143 @@ func NewAssumeRoleWithWebIdentityProvider(k keyring.Keyring, config *ProfileConf
 		WebIdentityTokenFile:    config.WebIdentityTokenFile,
 		WebIdentityTokenProcess: config.WebIdentityTokenProcess,
 		Duration:                config.AssumeRoleDuration,
+		PolicyARNs:              config.PolicyARNs,
+		Policy:                  config.Policy,
+	}
+
+	if useSessionCache {
+		return &CachedSessionProvider{
+			SessionKey: SessionMetadata{
+				Type:        "sts.AssumeRoleWithWebIdentity" + sessionPolicySuffix(config),
+				ProfileName: config.ProfileName,
+			},
+			Keyring:         &SessionKeyring{Keyring: k},
+			ExpiryWindow:    config.ExpiryWindow(),
+			OfflineGrace:    config.OfflineGrace,
+			SessionProvider: p,
+		}, nil
+	}
+
+	return p, nil
+}
+
+// NewCognitoIdentityProvider returns a provider that generates credentials
+// from an Amazon Cognito identity pool
+func NewCognitoIdentityProvider(k keyring.Keyring, config *ProfileConfig, useSessionCache bool) (aws.CredentialsProvider, error) {
+	region := cognitoIdentityPoolRegion(config.CognitoIdentityPoolID)
+	if region == "" {
+		region = config.Region
+	}
+	cfg := NewAwsConfig(region, config)
+
+	p := &CognitoIdentityProvider{
+		CognitoClient:           cognitoidentity.NewFromConfig(cfg),
+		IdentityPoolID:          config.CognitoIdentityPoolID,
+		LoginProvider:           config.CognitoLoginProvider,
+		WebIdentityTokenFile:    config.WebIdentityTokenFile,
+		WebIdentityTokenProcess: config.WebIdentityTokenProcess,
+		CustomRoleARN:           config.RoleARN,
+	}
+
+	if useSessionCache {
+		p.IdentityCache = CognitoIdentityKeyring{Keyring: k}
+		return &CachedSessionProvider{
+			SessionKey: SessionMetadata{
+				Type:        "cognito-identity.GetCredentialsForIdentity" + sessionSourceSuffix("pool", config.CognitoIdentityPoolID),
+				ProfileName: config.ProfileName,
+			},
+			Keyring:         &SessionKeyring{Keyring: k},
+			ExpiryWindow:    config.ExpiryWindow(),
+			OfflineGrace:    config.OfflineGrace,
+			SessionProvider: p,
+		}, nil
+	}
+
+	return p, nil
+}
+
+// NewAssumeRoleWithSAMLProvider returns a provider that generates
+// credentials using AssumeRoleWithSAML
+func NewAssumeRoleWithSAMLProvider(k keyring.Keyring, config *ProfileConfig, useSessionCache bool) (aws.CredentialsProvider, error) {
+	cfg := NewAwsConfig(config.Region, config)
+
+	p := &AssumeRoleWithSAMLProvider{
+		StsClient:            sts.NewFromConfig(cfg),
+		RoleARN:              config.RoleARN,
+		PrincipalARN:         config.PrincipalARN,
+		SAMLAssertionProcess: config.SAMLAssertionProcess,
+		SAMLIdpURL:           config.SAMLIdpURL,
+		Duration:             config.AssumeRoleDuration,
+		PolicyARNs:           config.PolicyARNs,
+		Policy:               config.Policy,
+		HTTPClient:           config.HTTPClient(),
+	}
+	if config.MfaPromptMethod == "terminal" {
+		p.rolePromptFunc = terminalSAMLRolePrompt
+	}
+
+	// Without role_arn the role can be chosen differently each time, so the session can't be cached by role
+	if useSessionCache && config.RoleARN == "" {
+		log.Printf("profile %s: not caching the SAML session as role_arn isn't set", config.ProfileName)
+		useSessionCache = false
+	}
+
+	if useSessionCache {
+		return &CachedSessionProvider{
+			SessionKey: SessionMetadata{
+				Type:        "sts.AssumeRoleWithSAML" + sessionSourceSuffix("role", config.RoleARN) + sessionPolicySuffix(config),
+				ProfileName: config.ProfileName,
+			},
+			Keyring:         &SessionKeyring{Keyring: k},
+			ExpiryWindow:    config.ExpiryWindow(),
+			OfflineGrace:    config.OfflineGrace,
+			SessionProvider: p,
+		}, nil
+	}
+
+	return p, nil
+}
+
+// NewRolesAnywhereProvider returns a provider that generates credentials
+// using IAM Roles Anywhere
+func NewRolesAnywhereProvider(k keyring.Keyring, config *ProfileConfig, useSessionCache bool) (aws.CredentialsProvider, error) {
+	// With a PKCS#11 certificate the signing helper finds the matching key in the token
+	keyRequired := !isPKCS11URI(config.RolesAnywhereCert)
+	if config.RolesAnywherePKCS12 == "" && (config.RolesAnywhereCert == "" || (keyRequired && config.RolesAnywherePrivateKey == "")) {
+		return nil, fmt.Errorf("profile %s: rolesanywhere_certificate and rolesanywhere_private_key, or rolesanywhere_pkcs12 must be set", config.ProfileName)
+	}
+	if isPKCS11URI(config.RolesAnywherePKCS12) {
+		return nil, fmt.Errorf("profile %s: rolesanywhere_pkcs12 must be a file, use rolesanywhere_certificate for a PKCS#11 URI", config.ProfileName)
+	}
+	if config.RolesAnywherePKCS11Lib != "" && !isPKCS11URI(config.RolesAnywhereCert) && !isPKCS11URI(config.RolesAnywherePrivateKey) {
+		return nil, fmt.Errorf("profile %s: rolesanywhere_pkcs11_lib can only be used with a PKCS#11 certificate or private key", config.ProfileName)
+	}
+
+	p := &RolesAnywhereProvider{
+		CertificateFile:  config.RolesAnywhereCert,
+		PrivateKeyFile:   config.RolesAnywherePrivateKey,
+		PKCS12File:       config.RolesAnywherePKCS12,
+		PassphrasePrompt: prompt.PassphraseMethods[config.MfaPromptMethod],
+		PKCS11Lib:        config.RolesAnywherePKCS11Lib,
+		TrustAnchorARN:   config.TrustAnchorARN,
+		ProfileARN:       config.ProfileARN,
+		RoleARN:          config.RoleARN,
+		RoleSessionName:  config.RoleSessionName,
+		Region:           config.Region,
+		Duration:         config.AssumeRoleDuration,
+		Endpoint:         config.EndpointURLFor("RolesAnywhere"),
+		HTTPClient:       config.HTTPClient(),
 	}
 
 	if useSessionCache {
 		return &CachedSessionProvider{
 			SessionKey: SessionMetadata{
-				Type:        "sts.AssumeRoleWithWebIdentity",
+				Type:        "rolesanywhere.CreateSession" + sessionSourceSuffix("role", config.RoleARN),
 				ProfileName: config.ProfileName,
 			},
 			Keyring:         &SessionKeyring{Keyring: k},
-			ExpiryWindow:    defaultExpirationWindow,
+			ExpiryWindow:    config.ExpiryWindow(),
+			OfflineGrace:    config.OfflineGrace,
 			SessionProvider: p,
 		}, nil
 	}
@@ -139,7 +370,7 @@ func NewAssumeRoleWithWebIdentityProvider(k keyring.Keyring, config *ProfileConf
 
 // NewSSORoleCredentialsProvider creates a provider for SSO credentials
 func NewSSORoleCredentialsProvider(k keyring.Keyring, config *ProfileConfig, useSessionCache bool) (aws.CredentialsProvider, error) {
-	cfg := NewAwsConfig(config.SSORegion, config.STSRegionalEndpoints)
+	cfg := NewAwsConfig(config.SSORegion, config)
 
 	ssoRoleCredentialsProvider := &SSORoleCredentialsProvider{
 		OIDCClient: ssooidc.NewFromConfig(cfg),
@@ -159,7 +390,8 @@ func NewSSORoleCredentialsProvider(k keyring.Keyring, config *ProfileConfig, use
 				MfaSerial:   config.SSOStartURL,
 			},
 			Keyring:         &SessionKeyring{Keyring: k},
-			ExpiryWindow:    defaultExpirationWindow,
+			ExpiryWindow:    config.ExpiryWindow(),
+			OfflineGrace:    config.OfflineGrace,
 			SessionProvider: ssoRoleCredentialsProvider,
 		}, nil
 	}
@@ -181,7 +413,8 @@ func NewCredentialProcessProvider(k keyring.Keyring, config *ProfileConfig, useS
 				ProfileName: config.ProfileName,
 			},
 			Keyring:         &SessionKeyring{Keyring: k},
-			ExpiryWindow:    defaultExpirationWindow,
+			ExpiryWindow:    config.ExpiryWindow(),
+			OfflineGrace:    config.OfflineGrace,
 			SessionProvider: credentialProcessProvider,
 		}, nil
 	}
@@ -190,7 +423,7 @@ func NewCredentialProcessProvider(k keyring.Keyring, config *ProfileConfig, useS
 }
 
 func NewFederationTokenProvider(ctx context.Context, credsProvider aws.CredentialsProvider, config *ProfileConfig) (*FederationTokenProvider, error) {
-	cfg := NewAwsConfigWithCredsProvider(credsProvider, config.Region, config.STSRegionalEndpoints)
+	cfg := NewAwsConfigWithCredsProvider(credsProvider, config.Region, config)
 
 	name, err := GetUsernameFromSession(ctx, cfg)
 	if err != nil {
@@ -199,9 +432,11 @@ func NewFederationTokenProvider(ctx context.Context, credsProvider aws.Credentia
 
 	log.Printf("Using GetFederationToken for credentials")
 	return &FederationTokenProvider{
-		StsClient: sts.NewFromConfig(cfg),
-		Name:      name,
-		Duration:  config.GetFederationTokenDuration,
+		StsClient:  sts.NewFromConfig(cfg),
+		Name:       name,
+		Duration:   config.GetFederationTokenDuration,
+		PolicyARNs: config.PolicyARNs,
+		Policy:     config.Policy,
 	}, nil
 }
 
@@ -249,6 +484,10 @@ func (t *TempCredentialsCreator) getSourceCreds(config *ProfileConfig, hasStored
 }
 
 func (t *TempCredentialsCreator) getSourceCredWithSession(config *ProfileConfig, hasStoredCredentials bool) (sourcecredsProvider aws.CredentialsProvider, err error) {
+	if config.HasAssumeRoot() {
+		return t.getAssumeRootProvider(config, hasStoredCredentials)
+	}
+
 	sourcecredsProvider, err = t.getSourceCreds(config, hasStoredCredentials)
 	if err != nil {
 		return nil, err
@@ -276,6 +515,34 @@ func (t *TempCredentialsCreator) getSourceCredWithSession(config *ProfileConfig,
 	return sourcecredsProvider, nil
 }
 
+// getAssumeRootProvider uses the source credentials as they are, as AssumeRoot only accepts long-term user
+// credentials or role credentials and doesn't take an MFA token
+func (t *TempCredentialsCreator) getAssumeRootProvider(config *ProfileConfig, hasStoredCredentials bool) (aws.CredentialsProvider, error) {
+	if config.HasMfaSerial() {
+		return nil, fmt.Errorf("profile %s: mfa_serial can't be used with target_principal as AssumeRoot doesn't take an MFA token, use a source_profile that assumes a role with MFA instead", config.ProfileName)
+	}
+
+	source := t
+	if !hasStoredCredentials && config.HasSourceProfile() {
+		source = &TempCredentialsCreator{
+			Keyring:                   t.Keyring,
+			DisableSessions:           t.DisableSessions,
+			DisableCache:              t.DisableCache,
+			DisableSessionsForProfile: config.SourceProfile.ProfileName,
+		}
+	}
+	sourcecredsProvider, err := source.getSourceCreds(config, hasStoredCredentials)
+	if err != nil {
+		return nil, err
+	}
+	if !hasStoredCredentials && isMasterCredentialsProvider(sourcecredsProvider) && config.SourceProfile.HasMfaSerial() {
+		return nil, fmt.Errorf("profile %s: mfa_serial of source profile %s can't be used with target_principal as AssumeRoot doesn't take an MFA token, use a source_profile that assumes a role with MFA instead", config.ProfileName, config.SourceProfile.ProfileName)
+	}
+
+	log.Printf("profile %s: using AssumeRoot for %s", config.ProfileName, config.TargetPrincipal)
+	return NewAssumeRootProvider(sourcecredsProvider, t.Keyring.Keyring, config, !t.DisableCache)
+}
+
 func (t *TempCredentialsCreator) GetProviderForProfile(config *ProfileConfig) (aws.CredentialsProvider, error) {
 	hasStoredCredentials, err := t.Keyring.Has(config.ProfileName)
 	if err != nil {
@@ -291,11 +558,26 @@ func (t *TempCredentialsCreator) GetProviderForProfile(config *ProfileConfig) (a
 		return NewSSORoleCredentialsProvider(t.Keyring.Keyring, config, !t.DisableCache)
 	}
 
+	if config.HasCognitoIdentityPool() {
+		log.Printf("profile %s: using Cognito identity pool", config.ProfileName)
+		return NewCognitoIdentityProvider(t.Keyring.Keyring, config, !t.DisableCache)
+	}
+
 	if config.HasWebIdentity() {
 		log.Printf("profile %s: using web identity", config.ProfileName)
 		return NewAssumeRoleWithWebIdentityProvider(t.Keyring.Keyring, config, !t.DisableCache)
 	}
 
+	if config.HasSAML() {
+		log.Printf("profile %s: using SAML federation", config.ProfileName)
+		return NewAssumeRoleWithSAMLProvider(t.Keyring.Keyring, config, !t.DisableCache)
+	}
+
+	if config.HasRolesAnywhere() {
+		log.Printf("profile %s: using IAM Roles Anywhere", config.ProfileName)
+		return NewRolesAnywhereProvider(t.Keyring.Keyring, config, !t.DisableCache)
+	}
+
 	if config.HasCredentialProcess() {
 		log.Printf("profile %s: using credential process", config.ProfileName)
 		return NewCredentialProcessProvider(t.Keyring.Keyring, config, !t.DisableCache)
@@ -1,8 +1,11 @@
 package vault_test
 
 import (
+	"context"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/99designs/aws-vault/v7/vault"
 	"github.com/99designs/keyring"
@@ -123,3 +126,120 @@ sso_registration_scopes=sso:account:access
 		t.Fatalf("Expected AccountID to be 2160xxxx, got %s", ssoProvider.AccountID)
 	}
 }
+
+func TestUsageCognitoIdentityPoolExample(t *testing.T) {
+	f := newConfigFile(t, []byte(`
+[profile mobile-authenticated]
+cognito_identity_pool_id = us-east-1:11111111-2222-3333-4444-555555555555
+cognito_login_provider = cognito-idp.us-east-1.amazonaws.com/us-east-1_EXAMPLE
+web_identity_token_process = oidccli raw
+`))
+	defer os.Remove(f)
+	configFile, err := vault.LoadConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configLoader := &vault.ConfigLoader{File: configFile, ActiveProfile: "mobile-authenticated"}
+	config, err := configLoader.GetProfileConfig("mobile-authenticated")
+	if err != nil {
+		t.Fatalf("Should have found a profile: %v", err)
+	}
+
+	ckr := &vault.CredentialKeyring{Keyring: keyring.NewArrayKeyring([]keyring.Item{})}
+	p, err := vault.NewTempCredentialsProvider(config, ckr, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cognitoProvider, ok := p.(*vault.CognitoIdentityProvider)
+	if !ok {
+		t.Fatalf("Expected CognitoIdentityProvider, got %T", p)
+	}
+	if cognitoProvider.LoginProvider != "cognito-idp.us-east-1.amazonaws.com/us-east-1_EXAMPLE" {
+		t.Fatalf("Unexpected login provider %s", cognitoProvider.LoginProvider)
+	}
+}
+
+func TestNewRoleHopsProvider(t *testing.T) {
+	config := &vault.ProfileConfig{ProfileName: "base", Region: "us-east-1", AssumeRoleDuration: 4 * time.Hour}
+	master := vault.NewMasterCredentialsProvider(&vault.CredentialKeyring{Keyring: keyring.NewArrayKeyring([]keyring.Item{})}, "base")
+
+	p := vault.NewRoleHopsProvider(master, config, []vault.RoleHop{
+		{RoleARN: "arn:aws:iam::111111111111:role/first"},
+	})
+	if p.RoleARN != "arn:aws:iam::111111111111:role/first" || p.Duration != 4*time.Hour {
+		t.Fatalf("Expected role first with duration 4h, got %s with %s", p.RoleARN, p.Duration)
+	}
+
+	p = vault.NewRoleHopsProvider(master, config, []vault.RoleHop{
+		{RoleARN: "arn:aws:iam::111111111111:role/first"},
+		{RoleARN: "arn:aws:iam::222222222222:role/second", ExternalID: "abc", SessionTags: map[string]string{"team": "ops"}},
+	})
+	if p.RoleARN != "arn:aws:iam::222222222222:role/second" || p.ExternalID != "abc" || p.Tags["team"] != "ops" {
+		t.Fatalf("Unexpected last role %+v", p)
+	}
+	if p.Duration != time.Hour {
+		t.Fatalf("Expected a chained role to be limited to 1h, got %s", p.Duration)
+	}
+}
+
+func TestAssumeRootUsesSourceCredentialsDirectly(t *testing.T) {
+	f := newConfigFile(t, []byte(`
+[profile management]
+region=us-east-1
+
+[profile unlock-bucket-policy]
+region=us-east-1
+source_profile=management
+target_principal=222222222222
+task_policy_arn=S3UnlockBucketPolicy
+
+[profile unlock-with-mfa]
+region=us-east-1
+mfa_serial=arn:aws:iam::111111111111:mfa/johnsmith
+target_principal=222222222222
+task_policy_arn=S3UnlockBucketPolicy
+`))
+	defer os.Remove(f)
+	configFile, err := vault.LoadConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configLoader := &vault.ConfigLoader{File: configFile, ActiveProfile: "unlock-bucket-policy"}
+	config, err := configLoader.GetProfileConfig("unlock-bucket-policy")
+	if err != nil {
+		t.Fatalf("Should have found a profile: %v", err)
+	}
+
+	ckr := &vault.CredentialKeyring{Keyring: keyring.NewArrayKeyring([]keyring.Item{
+		{Key: "management", Data: []byte(`{"AccessKeyID":"AKIAMANAGEMENT","SecretAccessKey":"secret"}`)},
+		{Key: "unlock-with-mfa", Data: []byte(`{"AccessKeyID":"AKIAMANAGEMENT","SecretAccessKey":"secret"}`)},
+	})}
+
+	// GetSessionToken credentials can't call AssumeRoot, so the long-term credentials are used
+	p, err := vault.NewTempCredentialsProvider(config, ckr, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*vault.AssumeRootProvider); !ok {
+		t.Fatalf("Expected AssumeRootProvider, got %T", p)
+	}
+	creds, err := p.(*vault.AssumeRootProvider).StsClient.Options().Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "AKIAMANAGEMENT" || creds.SessionToken != "" {
+		t.Fatalf("Expected the long-term credentials, got %v", creds)
+	}
+
+	// AssumeRoot doesn't take an MFA token
+	configLoader.ActiveProfile = "unlock-with-mfa"
+	config, err = configLoader.GetProfileConfig("unlock-with-mfa")
+	if err != nil {
+		t.Fatalf("Should have found a profile: %v", err)
+	}
+	_, err = vault.NewTempCredentialsProvider(config, ckr, false, true)
+	if err == nil || !strings.Contains(err.Error(), "mfa_serial can't be used with target_principal") {
+		t.Fatalf("Expected an mfa_serial error, got %v", err)
+	}
+}